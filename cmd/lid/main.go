@@ -1,6 +1,7 @@
 package main
 
 import (
+	"log"
 	"os/exec"
 	"strings"
 	"syscall"
@@ -13,15 +14,17 @@ func main() {
 
 	manager := lid.New()
 	manager.Register("pocketbase", lid.ServiceConfig{
-		Cwd:     "../../../convex/convex/pocketbase",
-		Command: []string{"./convex-pb", "serve"},
-		EnvFile: ".env",
+		Cwd:      "../../../convex/convex/pocketbase",
+		Command:  []string{"./convex-pb", "serve"},
+		EnvFiles: []string{".env"},
 		StdoutReadinessCheck: func(line string) bool {
 			return strings.Contains(line, "Server started at")
 		},
+		RestartPolicy: lid.RestartOnFailure,
+		MaxRestarts:   5,
+		RestartWindow: 1 * time.Minute,
 		OnExit: func(e *exec.ExitError, service *lid.Service) {
 			service.Logger.Println("POCKETBASE FAILED")
-			service.Start()
 		},
 	})
 
@@ -44,21 +47,17 @@ func main() {
 		OnAfterStart: func(service *lid.Service) {
 			service.Logger.Println("hello")
 		},
+		RestartPolicy: lid.RestartAlways,
 		OnExit: func(e *exec.ExitError, service *lid.Service) {
 			service.Logger.Println("> Failed")
-			service.Start()
 		},
 	})
 
-	manager.Register("docker", lid.ServiceConfig{
-		Cwd:                   "../../../convex/convex/convex-insights/scrape-server",
-		Command:               []string{"docker", "compose", "up"},
-		ExitCommand:           []string{"docker", "compose", "down"},
-		ReadinessCheckTimeout: 10 * time.Second,
-		StdoutReadinessCheck: func(line string) bool {
-			return strings.Contains(line, "WARP status: Connected")
-		},
-	})
+	config, err := lid.LoadConfig("lid.yaml")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	manager.RegisterConfig(config)
 
 	// manager.Register("b", lid.ServiceConfig{
 	// 	Cwd:     "../../../convex/convex/convex-insights/scrape-server",
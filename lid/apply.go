@@ -0,0 +1,268 @@
+package lid
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// ConfigHash hashes what the processes of the service are started with: the
+// Command, the Cwd and the environment on top of the inherited one, with the
+// env files as they are now. `lid apply` restarts a service whose hash
+// changed since it was started.
+func (s *Service) ConfigHash() (string, error) {
+	var inherited []string
+	if s.InheritEnv {
+		inherited = os.Environ()
+	}
+
+	env, err := s.configuredEnvironment(inherited)
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(env)
+
+	cwd, err := s.workDir()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(struct {
+		Command    []string `json:"command"`
+		Cwd        string   `json:"cwd"`
+		InheritEnv bool     `json:"inherit_env"`
+		Env        []string `json:"env"`
+	}{s.Command, cwd, s.InheritEnv, env})
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+type ApplyAction string
+
+const (
+	APPLY_START   ApplyAction = "start"
+	APPLY_RESTART ApplyAction = "restart"
+	APPLY_STOP    ApplyAction = "stop"
+	APPLY_KEEP    ApplyAction = "keep"
+)
+
+// ApplyStep is what `lid apply` does to one service.
+type ApplyStep struct {
+	Name   string      `json:"name"`
+	Action ApplyAction `json:"action"`
+	Reason string      `json:"reason"`
+}
+
+// Plan compares the registered services with the ones running from the state
+// directory and returns what Apply has to do, in the order of the names:
+//
+//   - start services that were added, or lost their supervisor
+//   - restart running services whose ConfigHash changed since they started
+//   - stop running services that are no longer registered
+//   - keep everything else as it is, including services that were stopped,
+//     exited, are FATAL or are waiting to be restarted
+func (lid *Lid) Plan() ([]ApplyStep, error) {
+	var steps []ApplyStep
+
+	for _, name := range lid.serviceNames() {
+		service := lid.services[name]
+		step := ApplyStep{Name: name, Action: APPLY_KEEP}
+
+		state, err := ReadServiceProcess(service.GetServiceProcessFilename())
+		switch {
+		case os.IsNotExist(err):
+			step.Action, step.Reason = APPLY_START, "added"
+
+		case !service.IsRunning() && state.Status == BACKOFF:
+			step.Reason = "waiting to be restarted"
+
+		// stopped on purpose or given up on, the next `lid start` runs it
+		// with the config as it is then
+		case !service.IsRunning() && (state.Status == STOPPED || state.Status == EXITED || state.Status == FATAL):
+			step.Reason = strings.ToLower(state.Status.String())
+
+		// its supervisor went down with it
+		case !service.IsRunning():
+			step.Action, step.Reason = APPLY_START, "not running"
+
+		default:
+			hash, err := service.ConfigHash()
+			if err != nil {
+				return nil, &ConfigError{Service: name, Err: err}
+			}
+
+			switch state.ConfigHash {
+			case hash:
+				step.Reason = "unchanged"
+			case "":
+				step.Action, step.Reason = APPLY_RESTART, "started without a config hash"
+			default:
+				step.Action, step.Reason = APPLY_RESTART, "config changed"
+			}
+		}
+
+		steps = append(steps, step)
+	}
+
+	removed, err := lid.removedServices()
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range removed {
+		steps = append(steps, ApplyStep{Name: service.Name, Action: APPLY_STOP, Reason: "removed"})
+	}
+
+	sort.Slice(steps, func(i, j int) bool {
+		return steps[i].Name < steps[j].Name
+	})
+	return steps, nil
+}
+
+// removedServices returns the services running from the state directory that
+// are no longer registered. They only know how to be stopped, with SIGTERM.
+func (lid *Lid) removedServices() ([]*Service, error) {
+	files, err := filepath.Glob(filepath.Join(lid.stateDir, "service-*.lid"))
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []*Service
+	for _, file := range files {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), "service-"), ".lid")
+		if _, ok := lid.services[name]; ok {
+			continue
+		}
+
+		service := NewService(name, ServiceConfig{
+			StateDir: lid.stateDir,
+			Logger:   lid.newLogger(io.MultiWriter(lid.terminal(os.Stdout), lid.logFile), name),
+		})
+		if service.IsRunning() {
+			removed = append(removed, service)
+		}
+	}
+	return removed, nil
+}
+
+// forget removes the state of a removed service once its supervisor is done
+// with it, so registering the service again counts as adding it.
+func (lid *Lid) forget(service *Service) error {
+	lock, err := lockFile(service.lockFilename("supervisor"), lid.lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	return os.Remove(service.GetServiceProcessFilename())
+}
+
+// Apply carries out a plan: it stops the removed services and removes their
+// state, then restarts the changed ones and starts the added ones, together
+// with their dependencies. It returns what it did to every service it touched.
+func (lid *Lid) Apply(steps []ApplyStep) []ServiceResult {
+	var removed, restarted, started []string
+	for _, step := range steps {
+		switch step.Action {
+		case APPLY_STOP:
+			removed = append(removed, step.Name)
+		case APPLY_RESTART:
+			restarted = append(restarted, step.Name)
+		case APPLY_START:
+			started = append(started, step.Name)
+		}
+	}
+
+	var results []ServiceResult
+	if len(removed) > 0 {
+		services, _ := lid.removedServices()
+		for _, service := range services {
+			if !contains(removed, service.Name) {
+				continue
+			}
+
+			result := lid.stopService(service)
+			result.Name, result.Action = service.Name, "stop"
+			if result.Outcome == OUTCOME_STOPPED {
+				if err := lid.forget(service); err != nil {
+					service.Logger.Printf("Could not remove the state of %s: %v\n", service.Name, err)
+				}
+			}
+			results = append(results, result)
+		}
+	}
+
+	var stopped []ServiceResult
+	if len(restarted) > 0 {
+		stopped = lid.StopServices(restarted)
+	}
+
+	if len(restarted) > 0 || len(started) > 0 {
+		for _, result := range lid.StartServices(append(restarted, started...)) {
+			if contains(restarted, result.Name) {
+				result = restartResults(stopped, []ServiceResult{result})[0]
+			}
+			results = append(results, result)
+		}
+	}
+
+	return results
+}
+
+// runApply runs `lid apply`, printing the plan before carrying it out.
+func (lid *Lid) runApply(client *rpc.Client, args []string) {
+	var dryRun, printJSON bool
+	flags := flag.NewFlagSet("apply", flag.ContinueOnError)
+	flags.BoolVar(&dryRun, "dry-run", false, "only print the plan")
+	flags.BoolVar(&printJSON, "json", false, "print the plan as a JSON object per service")
+
+	if _, err := parseArgs(flags, args); err != nil {
+		os.Exit(EXIT_USAGE)
+	}
+	lid.printingResults.Store(printJSON)
+
+	steps, err := lid.Plan()
+	if err != nil {
+		log.Println(err)
+		os.Exit(EXIT_FAILURE)
+	}
+
+	if printJSON {
+		exitOnPrintError(printEach(steps, commandOptions{JSON: true}))
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, step := range steps {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", step.Name, step.Action, step.Reason)
+		}
+		w.Flush()
+	}
+
+	if dryRun {
+		return
+	}
+
+	// the daemon would start the services with the config it was started with
+	if client != nil {
+		log.Println("lid apply cannot change the services of a running daemon, restart the daemon instead")
+		os.Exit(EXIT_FAILURE)
+	}
+
+	results := lid.Apply(steps)
+	if err := resultsError(ErrApplyFailed, results); err != nil {
+		log.Println(err)
+	}
+	os.Exit(ExitCode(results))
+}
@@ -0,0 +1,357 @@
+package lid
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/sys/unix"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is a set of services defined in a config file, see LoadConfig.
+type Config struct {
+	// The file the services were loaded from
+	Path string
+	// The services by name, ready to be registered. Hooks can be added to
+	// them before that.
+	Services map[string]ServiceConfig
+}
+
+// LoadConfig reads services from a lid.yaml, lid.toml or lid.json file,
+// picked by the extension of path. A relative path is relative to the
+// executable, like the Cwd of a service.
+//
+// The file defines every service under "services", with the fields of
+// ServiceConfig that need no code in snake_case:
+//
+//	services:
+//	  api:
+//	    command: ["./api", "--port", "8080"]
+//	    cwd: ../api
+//	    env: {LOG_LEVEL: debug, PORT: 8080}
+//	    readiness_pattern: "listening on :\\d+"
+//	    exit_signal: SIGINT
+//	    depends_on: [db]
+//
+// A relative cwd, log_file or err_log_file is relative to the directory of
+// the file, which is also the cwd of services that have none.
+func LoadConfig(path string) (*Config, error) {
+	path, err := getRelativePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := parseConfigFile(path, content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	config := &Config{
+		Path:     path,
+		Services: make(map[string]ServiceConfig, len(file.Services)),
+	}
+
+	for name, definition := range file.Services {
+		service, err := definition.serviceConfig(filepath.Dir(path))
+		if err != nil {
+			return nil, fmt.Errorf("%s: service '%s': %w", path, name, err)
+		}
+		config.Services[name] = service
+	}
+
+	return config, nil
+}
+
+// RegisterConfig registers every service of config, in the order of their
+// names. It returns ConfigErrors for the services Register rejected.
+func (lid *Lid) RegisterConfig(config *Config) error {
+	names := make([]string, 0, len(config.Services))
+	for name := range config.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs ConfigErrors
+	for _, name := range names {
+		if err := lid.Register(name, config.Services[name]); err != nil {
+			errs = append(errs, err.(*ConfigError))
+		}
+	}
+	return errs.err()
+}
+
+// configFile is the content of a config file. Whatever the format, it is
+// decoded like JSON, so only the json tags of the definitions matter.
+type configFile struct {
+	Services map[string]serviceDefinition `json:"services"`
+}
+
+// serviceDefinition is how a service is defined in a config file.
+type serviceDefinition struct {
+	Command    []string                  `json:"command"`
+	Cwd        string                    `json:"cwd"`
+	InheritEnv *bool                     `json:"inherit_env"`
+	EnvFiles   []string                  `json:"env_files"`
+	Env        map[string]configEnvValue `json:"env"`
+
+	GracefulShutdownTimeout configDuration `json:"graceful_shutdown_timeout"`
+	ReadinessCheckTimeout   configDuration `json:"readiness_check_timeout"`
+	// Regular expression matching the line of stdout the service is ready
+	// after, see StdoutReadinessCheck
+	ReadinessPattern string `json:"readiness_pattern"`
+
+	ExitSignal  string   `json:"exit_signal"`
+	ExitCommand []string `json:"exit_command"`
+
+	LogFile     string                 `json:"log_file"`
+	ErrLogFile  string                 `json:"err_log_file"`
+	LogRotation *logRotationDefinition `json:"log_rotation"`
+
+	Listeners   []string               `json:"listeners"`
+	DependsOn   []string               `json:"depends_on"`
+	HealthCheck *healthCheckDefinition `json:"health_check"`
+
+	RestartPolicy   RestartPolicy  `json:"restart_policy"`
+	MaxRestarts     int            `json:"max_restarts"`
+	RestartWindow   configDuration `json:"restart_window"`
+	RestartDelay    configDuration `json:"restart_delay"`
+	MaxRestartDelay configDuration `json:"max_restart_delay"`
+
+	CrashLoopThreshold int            `json:"crash_loop_threshold"`
+	CrashLoopWindow    configDuration `json:"crash_loop_window"`
+}
+
+type logRotationDefinition struct {
+	MaxSize    int64          `json:"max_size"`
+	MaxAge     configDuration `json:"max_age"`
+	MaxBackups int            `json:"max_backups"`
+	Compress   bool           `json:"compress"`
+}
+
+type healthCheckDefinition struct {
+	HTTP *struct {
+		URL            string `json:"url"`
+		ExpectedStatus int    `json:"expected_status"`
+		ExpectedBody   string `json:"expected_body"`
+	} `json:"http"`
+	TCP  string   `json:"tcp"`
+	Exec []string `json:"exec"`
+
+	Interval    configDuration `json:"interval"`
+	Timeout     configDuration `json:"timeout"`
+	Retries     int            `json:"retries"`
+	StartPeriod configDuration `json:"start_period"`
+	Readiness   bool           `json:"readiness"`
+
+	OnFailure     LivenessAction `json:"on_failure"`
+	FailureSignal string         `json:"failure_signal"`
+}
+
+// configDuration is a duration written like "1m30s".
+type configDuration time.Duration
+
+func (d *configDuration) UnmarshalText(text []byte) error {
+	duration, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = configDuration(duration)
+	return nil
+}
+
+// configEnvValue is the value of a variable in env. Numbers and booleans are
+// taken as written, so `PORT: 8080` needs no quotes.
+type configEnvValue string
+
+func (v *configEnvValue) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+
+	switch value.(type) {
+	case string, json.Number, bool:
+		*v = configEnvValue(fmt.Sprint(value))
+		return nil
+	default:
+		return fmt.Errorf("env value %s is not a string, number or boolean", data)
+	}
+}
+
+// parseConfigFile decodes a config file in the format its extension names.
+func parseConfigFile(path string, content []byte) (*configFile, error) {
+	var raw any
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(content, &raw); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		if err := toml.Unmarshal(content, &raw); err != nil {
+			return nil, err
+		}
+	case ".json":
+		if err := json.Unmarshal(content, &raw); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown config format %q, expected .yaml, .yml, .toml or .json", ext)
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var file configFile
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%s", strings.TrimPrefix(err.Error(), "json: "))
+	}
+	return &file, nil
+}
+
+// serviceConfig turns the definition into a ServiceConfig, resolving paths
+// against dir.
+func (d serviceDefinition) serviceConfig(dir string) (ServiceConfig, error) {
+	resolve := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(dir, path)
+	}
+
+	config := ServiceConfig{
+		Command:                 d.Command,
+		Cwd:                     resolve(d.Cwd),
+		InheritEnv:              d.InheritEnv,
+		EnvFiles:                d.EnvFiles,
+		GracefulShutdownTimeout: time.Duration(d.GracefulShutdownTimeout),
+		ReadinessCheckTimeout:   time.Duration(d.ReadinessCheckTimeout),
+		ExitCommand:             d.ExitCommand,
+		LogFile:                 resolve(d.LogFile),
+		ErrLogFile:              resolve(d.ErrLogFile),
+		Listeners:               d.Listeners,
+		DependsOn:               d.DependsOn,
+		RestartPolicy:           d.RestartPolicy,
+		MaxRestarts:             d.MaxRestarts,
+		RestartWindow:           time.Duration(d.RestartWindow),
+		RestartDelay:            time.Duration(d.RestartDelay),
+		MaxRestartDelay:         time.Duration(d.MaxRestartDelay),
+		CrashLoopThreshold:      d.CrashLoopThreshold,
+		CrashLoopWindow:         time.Duration(d.CrashLoopWindow),
+	}
+
+	if config.Cwd == "" {
+		config.Cwd = dir
+	}
+
+	// sorted, so the same file always gives the same environment
+	for key, value := range d.Env {
+		config.Env = append(config.Env, key+"="+string(value))
+	}
+	sort.Strings(config.Env)
+
+	if d.ReadinessPattern != "" {
+		pattern, err := regexp.Compile(d.ReadinessPattern)
+		if err != nil {
+			return config, fmt.Errorf("readiness_pattern: %w", err)
+		}
+		config.StdoutReadinessCheck = pattern.MatchString
+	}
+
+	if d.ExitSignal != "" {
+		signal, err := parseSignal(d.ExitSignal)
+		if err != nil {
+			return config, fmt.Errorf("exit_signal: %w", err)
+		}
+		config.ExitSignal = signal
+	}
+
+	if d.LogRotation != nil {
+		config.LogRotation = &LogRotation{
+			MaxSize:    d.LogRotation.MaxSize,
+			MaxAge:     time.Duration(d.LogRotation.MaxAge),
+			MaxBackups: d.LogRotation.MaxBackups,
+			Compress:   d.LogRotation.Compress,
+		}
+	}
+
+	if d.HealthCheck != nil {
+		healthCheck, err := d.HealthCheck.healthCheck()
+		if err != nil {
+			return config, fmt.Errorf("health_check: %w", err)
+		}
+		config.HealthCheck = healthCheck
+	}
+
+	return config, nil
+}
+
+func (d healthCheckDefinition) healthCheck() (*HealthCheck, error) {
+	if d.OnFailure == LivenessHook {
+		return nil, fmt.Errorf("on_failure: %s needs an OnFailureHook, which can only be set in Go", LivenessHook)
+	}
+
+	healthCheck := &HealthCheck{
+		TCP:         d.TCP,
+		Exec:        d.Exec,
+		Interval:    time.Duration(d.Interval),
+		Timeout:     time.Duration(d.Timeout),
+		Retries:     d.Retries,
+		StartPeriod: time.Duration(d.StartPeriod),
+		Readiness:   d.Readiness,
+		OnFailure:   d.OnFailure,
+	}
+
+	if d.HTTP != nil {
+		healthCheck.HTTP = &HTTPProbe{
+			URL:            d.HTTP.URL,
+			ExpectedStatus: d.HTTP.ExpectedStatus,
+			ExpectedBody:   d.HTTP.ExpectedBody,
+		}
+	}
+
+	if d.FailureSignal != "" {
+		signal, err := parseSignal(d.FailureSignal)
+		if err != nil {
+			return nil, fmt.Errorf("failure_signal: %w", err)
+		}
+		healthCheck.FailureSignal = signal
+	}
+
+	return healthCheck, nil
+}
+
+// parseSignal parses the name of a signal like "SIGINT" or "int".
+func parseSignal(name string) (syscall.Signal, error) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	signal := unix.SignalNum(name)
+	if signal == 0 {
+		return 0, fmt.Errorf("unknown signal %q", name)
+	}
+	return signal, nil
+}
@@ -1,89 +1,353 @@
 package lid
 
-// import (
-// 	"context"
-// 	"fmt"
-// 	"log/slog"
-// 	"net"
-// 	"net/rpc"
-// 	"os"
-// 	"os/signal"
-// 	"path/filepath"
-// 	"sync"
-// 	"syscall"
-// )
-
-// type Daemon struct {
-// 	listener   net.Listener
-// 	server     *rpc.Server
-// 	supervisor *Supervisor
-// 	ctx        context.Context
-// 	cancel     context.CancelFunc
-// }
-
-// type Supervisor struct {
-// 	services map[string]*Service
-// 	mu       sync.RWMutex
-// }
-
-// func NewDaemon() (*Daemon, error) {
-// 	ctx, cancel := context.WithCancel(context.Background())
-
-// 	supervisor := &Supervisor{
-// 		services: make(map[string]*Service),
-// 	}
-
-// 	server := rpc.NewServer()
-// 	server.RegisterName("Lid", supervisor)
-
-// 	// socket
-// 	socketPath := filepath.Join(os.TempDir(), "lid.sock")
-// 	os.Remove(socketPath)
-// 	listener, err := net.Listen("unix", socketPath)
-// 	if err != nil {
-// 		cancel()
-// 		return nil, fmt.Errorf("failed to listen on socket: %w", err)
-// 	}
-
-// 	return &Daemon{
-// 		listener:   listener,
-// 		server:     server,
-// 		supervisor: supervisor,
-// 		ctx:        ctx,
-// 		cancel:     cancel,
-// 	}, nil
-// }
-
-// func (d *Daemon) Run() error {
-// 	defer d.listener.Close()
-
-// 	sigCh := make(chan os.Signal, 1)
-// 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-
-// 	go func() {
-// 		for {
-// 			conn, err := d.listener.Accept()
-// 			if err != nil {
-// 				select {
-// 				case <-d.ctx.Done():
-// 					return
-// 				default:
-// 					slog.Error("failed to accept connection", "error", err)
-// 					continue
-// 				}
-// 			}
-
-// 			go d.server.ServeConn(conn)
-// 		}
-// 	}()
-
-// 	d.supervisor.MonitorServices(d.ctx)
-// 	<-sigCh
-// 	d.Shutdown()
-// 	return nil
-// }
-
-// func (d *Daemon) Shutdown() {
-// 	d.cancel()
-// 	d.listener.Close()
-// }
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/rpc"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// Daemon is the optional long-lived supervisor started with `lid daemon`.
+// While it runs, the CLI hands start, stop, reload and list over to it through
+// a unix socket, and the daemon owns the processes of the services it starts.
+type Daemon struct {
+	lid        *Lid
+	listener   net.Listener
+	server     *rpc.Server
+	supervisor *Supervisor
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+// Supervisor is the RPC service the CLI talks to. It runs the Start loop of
+// every service started through it, which reaps the processes and applies
+// their restart policies.
+type Supervisor struct {
+	lid      *Lid
+	services map[string]chan struct{} // closed once the service's Start returns
+	mu       sync.RWMutex
+}
+
+type DaemonArgs struct {
+	Services []string
+}
+
+// DaemonReply maps every service an action was applied to to its status
+// afterwards, and tells what the action did to it.
+type DaemonReply struct {
+	Statuses map[string]ServiceStatus
+	Results  []ServiceResult
+}
+
+// socketPath returns the daemon's socket. It lives in the state directory so
+// lid binaries of different projects never talk to each other's daemon.
+func (lid *Lid) socketPath() string {
+	return filepath.Join(lid.stateDir, "lid.sock")
+}
+
+func NewDaemon(lid *Lid) (*Daemon, error) {
+	socketPath := lid.socketPath()
+
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("daemon is already running on %s", socketPath)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	supervisor := &Supervisor{
+		lid:      lid,
+		services: make(map[string]chan struct{}),
+	}
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("Lid", supervisor); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to register rpc server: %w", err)
+	}
+
+	// socket
+	os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to listen on socket: %w", err)
+	}
+
+	lid.supervisor = supervisor
+
+	return &Daemon{
+		lid:        lid,
+		listener:   listener,
+		server:     server,
+		supervisor: supervisor,
+		ctx:        ctx,
+		cancel:     cancel,
+	}, nil
+}
+
+// Run serves the CLI until the daemon receives SIGINT or SIGTERM, then stops
+// every service it owns.
+func (d *Daemon) Run() error {
+	defer d.listener.Close()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	// services owned by the daemon are reloaded through RPC, a stray
+	// `lid reload` signal must not kill it
+	signal.Ignore(RELOAD_SIGNAL)
+
+	go func() {
+		for {
+			conn, err := d.listener.Accept()
+			if err != nil {
+				select {
+				case <-d.ctx.Done():
+					return
+				default:
+					d.lid.logger.Printf("Failed to accept connection: %v\n", err)
+					continue
+				}
+			}
+
+			go d.server.ServeConn(conn)
+		}
+	}()
+
+	d.lid.logger.Printf("Daemon listening on %s\n", d.listener.Addr())
+	fmt.Printf("lid daemon listening on %s (pid %d)\n", d.listener.Addr(), os.Getpid())
+
+	d.supervisor.resurrect()
+
+	<-sigCh
+	d.Shutdown()
+	return nil
+}
+
+func (d *Daemon) Shutdown() {
+	d.cancel()
+	d.listener.Close()
+
+	owned := d.supervisor.owned()
+	if len(owned) > 0 {
+		d.lid.Stop(owned)
+	}
+	d.supervisor.wait()
+	d.lid.logger.Println("Daemon stopped")
+}
+
+// resurrect starts services whose restart policy asks for it when the daemon
+// comes up: RestartAlways services unless they are FATAL, RestartUnlessStopped
+// services unless they were stopped with `lid stop` as well.
+func (sup *Supervisor) resurrect() {
+	var names []string
+	for _, name := range sup.lid.serviceNames() {
+		service := sup.lid.services[name]
+		status := service.GetCachedStatus()
+
+		switch service.RestartPolicy {
+		case RestartAlways:
+			if status != FATAL {
+				names = append(names, name)
+			}
+		case RestartUnlessStopped:
+			if status != FATAL && status != STOPPED {
+				names = append(names, name)
+			}
+		}
+	}
+
+	if len(names) > 0 {
+		sup.lid.logger.Printf("Starting %v according to their restart policy\n", names)
+		sup.lid.Start(names)
+	}
+}
+
+// spawn runs the Start loop of a service inside the daemon and waits for it
+// to report whether the service became ready. It returns ErrServiceSupervised
+// if the loop already runs.
+func (sup *Supervisor) spawn(service *Service) error {
+	sup.mu.Lock()
+	if _, ok := sup.services[service.Name]; ok {
+		sup.mu.Unlock()
+		return ErrServiceSupervised
+	}
+
+	done := make(chan struct{})
+	sup.services[service.Name] = done
+	sup.mu.Unlock()
+
+	events, eventsWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer events.Close()
+
+	reporter := newSpawnReporter(eventsWriter)
+	service.setSpawnReporter(reporter)
+
+	go func() {
+		defer func() {
+			sup.mu.Lock()
+			delete(sup.services, service.Name)
+			sup.mu.Unlock()
+			close(done)
+		}()
+
+		if err := service.Start(); err != nil {
+			service.Logger.Printf("Could not start %s: %v\n", service.Name, err)
+			reporter.report(spawnEvent{Type: SPAWN_FAILED, Reason: err.Error()})
+		}
+	}()
+
+	return awaitSpawn(service, events)
+}
+
+// owns reports whether the service's Start loop runs in the daemon.
+func (sup *Supervisor) owns(name string) bool {
+	sup.mu.RLock()
+	defer sup.mu.RUnlock()
+	_, ok := sup.services[name]
+	return ok
+}
+
+func (sup *Supervisor) owned() []string {
+	sup.mu.RLock()
+	defer sup.mu.RUnlock()
+
+	var names []string
+	for _, name := range sup.lid.serviceNames() {
+		if _, ok := sup.services[name]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// wait blocks until the Start loops of all services returned.
+func (sup *Supervisor) wait() {
+	sup.mu.RLock()
+	pending := make([]chan struct{}, 0, len(sup.services))
+	for _, done := range sup.services {
+		pending = append(pending, done)
+	}
+	sup.mu.RUnlock()
+
+	for _, done := range pending {
+		<-done
+	}
+}
+
+func (sup *Supervisor) reply(services []string, reply *DaemonReply) {
+	reply.Statuses = make(map[string]ServiceStatus)
+	for _, info := range sup.lid.Statuses(services) {
+		reply.Statuses[info.Name] = info.Status
+	}
+}
+
+func (sup *Supervisor) Start(args DaemonArgs, reply *DaemonReply) error {
+	reply.Results = sup.lid.StartServices(args.Services)
+	sup.reply(sup.lid.withDependencies(args.Services), reply)
+	return nil
+}
+
+func (sup *Supervisor) Stop(args DaemonArgs, reply *DaemonReply) error {
+	reply.Results = sup.lid.StopServices(args.Services)
+	sup.reply(args.Services, reply)
+	return nil
+}
+
+func (sup *Supervisor) Reload(args DaemonArgs, reply *DaemonReply) error {
+	reply.Results = sup.lid.ReloadServices(args.Services)
+	sup.reply(args.Services, reply)
+	return nil
+}
+
+func (sup *Supervisor) List(args DaemonArgs, reply *[]ServiceInfo) error {
+	*reply = sup.lid.Statuses(args.Services)
+	return nil
+}
+
+func (sup *Supervisor) LogsFilename(args DaemonArgs, reply *string) error {
+	*reply = sup.lid.logsFilename
+	return nil
+}
+
+// dialDaemon connects to the daemon, or returns nil if it is not running.
+func (lid *Lid) dialDaemon() *rpc.Client {
+	client, err := rpc.Dial("unix", lid.socketPath())
+	if err != nil {
+		return nil
+	}
+	return client
+}
+
+func printDaemonReply(reply DaemonReply) {
+	names := make([]string, 0, len(reply.Statuses))
+	for name := range reply.Statuses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Printf("%s: %s\n", name, reply.Statuses[name])
+	}
+}
+
+// callDaemon hands a command printing results over to the daemon.
+func (lid *Lid) callDaemon(client *rpc.Client, command string, services []string) (DaemonReply, error) {
+	args := DaemonArgs{Services: services}
+	var reply DaemonReply
+
+	switch command {
+	case "start":
+		return reply, client.Call("Lid.Start", args, &reply)
+	case "stop":
+		return reply, client.Call("Lid.Stop", args, &reply)
+	case "restart":
+		var stopped DaemonReply
+		if err := client.Call("Lid.Stop", args, &stopped); err != nil {
+			return reply, err
+		}
+		err := client.Call("Lid.Start", args, &reply)
+		reply.Results = restartResults(stopped.Results, reply.Results)
+		return reply, err
+	case "reload":
+		return reply, client.Call("Lid.Reload", args, &reply)
+	default:
+		return reply, fmt.Errorf("the daemon does not handle '%s'", command)
+	}
+}
+
+// daemonStatuses asks the daemon for the statuses of services.
+func (lid *Lid) daemonStatuses(client *rpc.Client, services []string) ([]ServiceInfo, error) {
+	var infos []ServiceInfo
+	err := client.Call("Lid.List", DaemonArgs{Services: services}, &infos)
+	return infos, err
+}
+
+// useDaemonLogs makes `lid logs` follow the log of the daemon.
+func (lid *Lid) useDaemonLogs(client *rpc.Client) {
+	var logsFilename string
+	err := client.Call("Lid.LogsFilename", DaemonArgs{}, &logsFilename)
+	if lid.daemonCallDone(err) {
+		lid.logsFilename = logsFilename
+	}
+}
+
+// daemonCallDone reports whether a call reached the daemon, falling back to
+// the daemonless mode if it did not.
+func (lid *Lid) daemonCallDone(err error) bool {
+	if err != nil {
+		lid.logger.Printf("Daemon call failed, falling back to daemonless mode: %v\n", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,164 @@
+package lid
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CheckDependencies reports references to unknown services and dependency
+// cycles between the registered services.
+func (lid *Lid) CheckDependencies() error {
+	names := lid.serviceNames()
+
+	for _, name := range names {
+		for _, dep := range lid.services[name].DependsOn {
+			if _, ok := lid.services[dep]; !ok {
+				return fmt.Errorf("service '%s' depends on unknown service '%s'", name, dep)
+			}
+		}
+	}
+
+	return lid.dependencyCycle()
+}
+
+// dependencyCycle reports the first dependency cycle between the registered
+// services, ignoring unknown services.
+func (lid *Lid) dependencyCycle() error {
+	names := lid.serviceNames()
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[string]int, len(names))
+	var path []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			start := 0
+			for path[start] != name {
+				start++
+			}
+			cycle := append(append([]string{}, path[start:]...), name)
+			return fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range lid.services[name].DependsOn {
+			if _, ok := lid.services[dep]; !ok {
+				continue
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (lid *Lid) serviceNames() []string {
+	names := make([]string, 0, len(lid.services))
+	for name := range lid.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// selectServices returns the registered services among names, or all of them
+// if names is empty.
+func (lid *Lid) selectServices(names []string) []string {
+	if len(names) == 0 {
+		return lid.serviceNames()
+	}
+
+	selected := make([]string, 0, len(names))
+	for _, name := range lid.serviceNames() {
+		if contains(names, name) {
+			selected = append(selected, name)
+		}
+	}
+	return selected
+}
+
+// withDependencies returns the selected services together with everything
+// they depend on, directly or not.
+func (lid *Lid) withDependencies(names []string) []string {
+	included := make(map[string]bool)
+
+	var include func(name string)
+	include = func(name string) {
+		service, ok := lid.services[name]
+		if !ok || included[name] {
+			return
+		}
+
+		included[name] = true
+		for _, dep := range service.DependsOn {
+			include(dep)
+		}
+	}
+
+	for _, name := range lid.selectServices(names) {
+		include(name)
+	}
+
+	all := make([]string, 0, len(included))
+	for _, name := range lid.serviceNames() {
+		if included[name] {
+			all = append(all, name)
+		}
+	}
+	return all
+}
+
+// dependents returns, for every service in names, the services in names that
+// depend on it.
+func (lid *Lid) dependents(names []string) map[string][]string {
+	dependents := make(map[string][]string, len(names))
+	for _, name := range names {
+		for _, dep := range lid.services[name].DependsOn {
+			if contains(names, dep) {
+				dependents[dep] = append(dependents[dep], name)
+			}
+		}
+	}
+	return dependents
+}
+
+// waitUntilRunning waits for a service that is starting to pass its readiness
+// check and reports whether it is running.
+func waitUntilRunning(service *Service, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		status := service.GetCachedStatus()
+		if status == RUNNING {
+			return service.IsRunning()
+		}
+
+		if status != STARTING || time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -1,20 +1,220 @@
 package lid
 
 import (
+	"errors"
 	"fmt"
-
-	"github.com/joho/godotenv"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
 )
 
+// OPTIONAL_ENV_FILE_SUFFIX marks an entry of EnvFiles as optional: the file
+// is skipped if it does not exist.
+const OPTIONAL_ENV_FILE_SUFFIX = "?"
+
+// EnvFileError is a problem with an env file, at Line if it is not 0.
+type EnvFileError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *EnvFileError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("env file %s: %v", e.File, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+func (e *EnvFileError) Unwrap() error {
+	return e.Err
+}
+
+// ReadDotEnvFile reads the variables of an env file as KEY=value, expanding
+// references to variables defined earlier in the file.
 func ReadDotEnvFile(filename string) ([]string, error) {
-	envMap, err := godotenv.Read(filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read env file: %w", err)
+	return readEnvFile(filename, nil)
+}
+
+// readEnvFile reads the variables of an env file as KEY=value. ${VAR} and
+// $VAR in values that are not single-quoted are expanded against env and the
+// variables defined before them in the file, to nothing if they are not set.
+//
+// Every line is empty, a # comment or KEY=value, optionally starting with
+// "export ". Values may be single-quoted (taken literally), double-quoted
+// (with \n, \t, \", \\ and \$ escapes, and spanning lines) or unquoted (up to
+// a " #" comment, with surrounding spaces trimmed).
+func readEnvFile(filename string, env []string) ([]string, error) {
+	content, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, &EnvFileError{File: filename, Err: fmt.Errorf("%w (append %q to its name in EnvFiles if it is optional)", fs.ErrNotExist, OPTIONAL_ENV_FILE_SUFFIX)}
+	} else if err != nil {
+		return nil, &EnvFileError{File: filename, Err: err}
 	}
 
-	env := make([]string, 0, len(envMap))
-	for k, v := range envMap {
-		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	var vars []string
+	lookup := func(key string) string {
+		for i := len(vars) - 1; i >= 0; i-- {
+			if k, v, _ := strings.Cut(vars[i], "="); k == key {
+				return v
+			}
+		}
+		for i := len(env) - 1; i >= 0; i-- {
+			if k, v, _ := strings.Cut(env[i], "="); k == key {
+				return v
+			}
+		}
+		return ""
+	}
+
+	lines := strings.Split(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNumber := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fail := func(format string, args ...any) error {
+			return &EnvFileError{File: filename, Line: lineNumber, Err: fmt.Errorf(format, args...)}
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+		key, raw, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok {
+			return nil, fail("expected KEY=value, got %q", line)
+		}
+		if !ENV_KEY_PATTERN.MatchString(key) {
+			return nil, fail("invalid variable name %q", key)
+		}
+		raw = strings.TrimLeft(raw, " \t")
+
+		var value string
+		switch {
+		case strings.HasPrefix(raw, "'"):
+			end := strings.Index(raw[1:], "'")
+			if end < 0 {
+				return nil, fail("unterminated single-quoted value of %s", key)
+			}
+			value = raw[1 : end+1]
+			if rest := strings.TrimSpace(raw[end+2:]); rest != "" && !strings.HasPrefix(rest, "#") {
+				return nil, fail("unexpected %q after the value of %s", rest, key)
+			}
+
+		case strings.HasPrefix(raw, `"`):
+			// the value may continue on the following lines
+			quoted := raw[1:]
+			for {
+				end := closingQuote(quoted)
+				if end >= 0 {
+					if rest := strings.TrimSpace(quoted[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+						return nil, fail("unexpected %q after the value of %s", rest, key)
+					}
+					quoted = quoted[:end]
+					break
+				}
+
+				if i+1 >= len(lines) {
+					return nil, fail("unterminated double-quoted value of %s", key)
+				}
+				i++
+				quoted += "\n" + lines[i]
+			}
+			value = expandEnv(unescapeEnvValue(quoted), lookup)
+
+		default:
+			if comment := strings.Index(raw, " #"); comment >= 0 {
+				raw = raw[:comment]
+			}
+			value = expandEnv(strings.TrimSpace(raw), lookup)
+		}
+
+		vars = append(vars, key+"="+value)
+	}
+
+	return mergeEnv(vars), nil
+}
+
+// ENV_KEY_PATTERN matches the names of variables an env file may define.
+var ENV_KEY_PATTERN = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// closingQuote returns the index of the first double quote in s that is not
+// escaped, or -1.
+func closingQuote(s string) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// unescapeEnvValue resolves the escapes of a double-quoted value. An escaped
+// $ is kept escaped for expandEnv.
+func unescapeEnvValue(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case '$':
+			b.WriteString(`\$`)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// expandEnv replaces ${VAR} and $VAR in s with their values according to
+// lookup. \$ stands for a literal $.
+func expandEnv(s string, lookup func(key string) string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == '$':
+			b.WriteByte('$')
+			i++
+
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				b.WriteString(s[i:])
+				return b.String()
+			}
+			b.WriteString(lookup(s[i+2 : i+end]))
+			i += end
+
+		case s[i] == '$':
+			end := i + 1
+			for end < len(s) && (s[end] == '_' || 'A' <= s[end] && s[end] <= 'Z' || 'a' <= s[end] && s[end] <= 'z' || end > i+1 && '0' <= s[end] && s[end] <= '9') {
+				end++
+			}
+			if end == i+1 {
+				b.WriteByte('$')
+				continue
+			}
+			b.WriteString(lookup(s[i+1 : end]))
+			i = end - 1
+
+		default:
+			b.WriteByte(s[i])
+		}
 	}
-	return env, nil
+	return b.String()
 }
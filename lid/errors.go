@@ -5,5 +5,18 @@ import "fmt"
 var (
 	ErrProcessNotFound       = fmt.Errorf("process not found")
 	ErrProcessCorrupt        = fmt.Errorf("process corrupt")
+	ErrProcessStale          = fmt.Errorf("process was replaced by another program")
 	ErrProcessAlreadyRunning = fmt.Errorf("service is already running")
+	ErrReadinessTimeout      = fmt.Errorf("readiness check timed out")
+	ErrStartFailed           = fmt.Errorf("service failed to start")
+	ErrStopFailed            = fmt.Errorf("service failed to stop")
+	ErrReloadFailed          = fmt.Errorf("service failed to reload")
+	ErrServiceDown           = fmt.Errorf("service already down")
+	ErrReloadInProgress      = fmt.Errorf("a reload is already in progress")
+	ErrServiceLocked         = fmt.Errorf("service is locked by another lid process")
+	ErrServiceSupervised     = fmt.Errorf("service is already supervised by the daemon")
+	ErrApplyFailed           = fmt.Errorf("config failed to apply")
+	ErrEmptyCommand          = fmt.Errorf("command is empty")
+	ErrServiceRegistered     = fmt.Errorf("service is already registered")
+	ErrInvalidServiceName    = fmt.Errorf("service names cannot be empty or contain slashes or spaces")
 )
@@ -0,0 +1,182 @@
+package lid
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// FOLLOW_POLL_INTERVAL is how often a followed file is checked for changes
+// when the OS cannot tell. Even with inotify it is checked every
+// FOLLOW_RECHECK_INTERVAL, as network file systems do not report changes.
+const (
+	FOLLOW_POLL_INTERVAL    = 200 * time.Millisecond
+	FOLLOW_RECHECK_INTERVAL = 2 * time.Second
+)
+
+// fileWatcher reports that a file might have changed.
+type fileWatcher interface {
+	Events() <-chan struct{}
+	Close() error
+}
+
+// pollWatcher reports a change every interval, for when the OS cannot watch
+// files.
+type pollWatcher struct {
+	ticker *time.Ticker
+	events chan struct{}
+	done   chan struct{}
+}
+
+func newPollWatcher(interval time.Duration) *pollWatcher {
+	w := &pollWatcher{
+		ticker: time.NewTicker(interval),
+		events: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(w.events)
+		for {
+			select {
+			case <-w.ticker.C:
+				select {
+				case w.events <- struct{}{}:
+				case <-w.done:
+					return
+				}
+			case <-w.done:
+				return
+			}
+		}
+	}()
+
+	return w
+}
+
+func (w *pollWatcher) Events() <-chan struct{} {
+	return w.events
+}
+
+func (w *pollWatcher) Close() error {
+	w.ticker.Stop()
+	close(w.done)
+	return nil
+}
+
+// followFile calls callback with every line appended to filename after
+// offset (or after its current end if offset is negative) until callback
+// returns false or ctx is done. It waits for the file to be created, starts
+// over when it is truncated and follows it to the new file when it is
+// rotated.
+func followFile(ctx context.Context, filename string, offset int64, callback func(line string) bool) error {
+	watcher, err := newFileWatcher(filename)
+	if err != nil {
+		watcher = newPollWatcher(FOLLOW_POLL_INTERVAL)
+	}
+	defer func() { watcher.Close() }()
+
+	wait := func() error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case _, ok := <-watcher.Events():
+			if !ok {
+				// the OS stopped watching, keep going without it
+				watcher.Close()
+				watcher = newPollWatcher(FOLLOW_POLL_INTERVAL)
+			}
+		case <-time.After(FOLLOW_RECHECK_INTERVAL):
+		}
+		return nil
+	}
+
+	var file *os.File
+	for file == nil {
+		file, err = os.Open(filename)
+		if errors.Is(err, os.ErrNotExist) {
+			if err := wait(); err != nil {
+				return err
+			}
+			// everything in a file created after we started is new
+			offset = 0
+			continue
+		} else if err != nil {
+			return err
+		}
+	}
+	defer func() { file.Close() }()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if offset < 0 {
+		offset = info.Size()
+	}
+
+	// a file smaller than offset was rotated or truncated in the meantime
+	if offset > info.Size() {
+		offset = 0
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(file)
+	position := offset
+	partial := ""
+
+	for {
+		line, err := reader.ReadString('\n')
+		position += int64(len(line))
+
+		if err == nil {
+			if !callback(partial + line) {
+				return nil
+			}
+			partial = ""
+			continue
+		}
+
+		if !errors.Is(err, io.EOF) {
+			return err
+		}
+		partial += line
+
+		current, err := file.Stat()
+		if err != nil {
+			return err
+		}
+
+		// a rotated file is not written anymore once we read all of it
+		if info, err := os.Stat(filename); err == nil && !os.SameFile(info, current) {
+			if next, err := os.Open(filename); err == nil {
+				file.Close()
+				file = next
+				reader.Reset(file)
+				position = 0
+				continue
+			}
+		}
+
+		if current.Size() < position {
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			reader.Reset(file)
+			position = 0
+			partial = ""
+			continue
+		}
+
+		if err := wait(); err != nil {
+			return err
+		}
+	}
+}
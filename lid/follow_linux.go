@@ -0,0 +1,85 @@
+package lid
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// inotifyWatcher watches the directory of a file with inotify, so renames
+// and re-creations of the file are seen as well as writes to it.
+type inotifyWatcher struct {
+	file   *os.File
+	events chan struct{}
+}
+
+func newFileWatcher(filename string) (fileWatcher, error) {
+	filename, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	mask := uint32(syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO)
+	if _, err := syscall.InotifyAddWatch(fd, filepath.Dir(filename), mask); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	w := &inotifyWatcher{
+		// a non-blocking file is read through the runtime poller, so Close
+		// interrupts a pending Read
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		events: make(chan struct{}, 1),
+	}
+	go w.read(filepath.Base(filename))
+
+	return w, nil
+}
+
+func (w *inotifyWatcher) read(name string) {
+	defer close(w.events)
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		changed := false
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			// struct inotify_event { int wd; uint32_t mask, cookie, len; char name[]; }
+			mask := binary.NativeEndian.Uint32(buf[offset+4:])
+			length := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+			start := offset + syscall.SizeofInotifyEvent
+			offset = start + length
+
+			if mask&syscall.IN_Q_OVERFLOW != 0 || strings.TrimRight(string(buf[start:min(offset, n)]), "\x00") == name {
+				changed = true
+			}
+		}
+
+		if changed {
+			// a pending event covers this one as well
+			select {
+			case w.events <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (w *inotifyWatcher) Events() <-chan struct{} {
+	return w.events
+}
+
+func (w *inotifyWatcher) Close() error {
+	return w.file.Close()
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package lid
+
+func newFileWatcher(filename string) (fileWatcher, error) {
+	return newPollWatcher(FOLLOW_POLL_INTERVAL), nil
+}
@@ -0,0 +1,352 @@
+package lid
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type HealthStatus int8
+
+const (
+	HEALTH_UNKNOWN HealthStatus = iota
+	HEALTHY
+	UNHEALTHY
+)
+
+func (h HealthStatus) String() string {
+	switch h {
+	case HEALTHY:
+		return "Healthy"
+	case UNHEALTHY:
+		return "Unhealthy"
+	default:
+		return "Unknown"
+	}
+}
+
+func (h HealthStatus) MarshalText() ([]byte, error) {
+	if h < HEALTH_UNKNOWN || h > UNHEALTHY {
+		return nil, fmt.Errorf("unknown health status %d", h)
+	}
+	return []byte(strings.ToLower(h.String())), nil
+}
+
+func (h *HealthStatus) UnmarshalText(text []byte) error {
+	for health := HEALTH_UNKNOWN; health <= UNHEALTHY; health++ {
+		if strings.EqualFold(string(text), health.String()) {
+			*h = health
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown health status %q", text)
+}
+
+type LivenessAction int8
+
+const (
+	// Only record the service as unhealthy (default)
+	LivenessMarkUnhealthy LivenessAction = iota
+	// Stop the process and start it again, regardless of the RestartPolicy
+	LivenessRestart
+	// Send FailureSignal to the process
+	LivenessSignal
+	// Call OnFailureHook
+	LivenessHook
+)
+
+func (a LivenessAction) String() string {
+	switch a {
+	case LivenessMarkUnhealthy:
+		return "mark-unhealthy"
+	case LivenessRestart:
+		return "restart"
+	case LivenessSignal:
+		return "signal"
+	case LivenessHook:
+		return "hook"
+	default:
+		return "unknown"
+	}
+}
+
+func (a *LivenessAction) UnmarshalText(text []byte) error {
+	for action := LivenessMarkUnhealthy; action <= LivenessHook; action++ {
+		if strings.EqualFold(string(text), action.String()) {
+			*a = action
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown liveness action %q", text)
+}
+
+// HealthCheck actively probes a running service. Set one of HTTP, TCP or Exec.
+type HealthCheck struct {
+	HTTP *HTTPProbe // GET a URL
+	TCP  string     // Connect to an address, e.g. "localhost:8080"
+	Exec []string   // Run a command in the service's Cwd, healthy if it exits with 0
+
+	Interval    time.Duration // Time between probes (defaults to 10s)
+	Timeout     time.Duration // Time a single probe may take (defaults to 5s)
+	Retries     int           // Consecutive failures before the service is unhealthy (defaults to 3)
+	StartPeriod time.Duration // Failures within this period after the process started do not count
+
+	// Wait for the first successful probe (after StdoutReadinessCheck, if any)
+	// before the service counts as ready. Bounded by ReadinessCheckTimeout.
+	Readiness bool
+
+	// What to do every time the probe failed Retries times in a row, e.g. to
+	// get a deadlocked process going again. Defaults to LivenessMarkUnhealthy.
+	OnFailure     LivenessAction
+	FailureSignal syscall.Signal      // Sent by LivenessSignal (defaults to SIGKILL)
+	OnFailureHook func(self *Service) // Called by LivenessHook
+}
+
+type HTTPProbe struct {
+	URL            string
+	ExpectedStatus int    // Defaults to any 2xx or 3xx status
+	ExpectedBody   string // Substring the response body has to contain
+}
+
+func (hc HealthCheck) withDefaults() *HealthCheck {
+	if hc.Interval == 0 {
+		hc.Interval = 10 * time.Second
+	}
+
+	if hc.Timeout == 0 {
+		hc.Timeout = 5 * time.Second
+	}
+
+	if hc.Retries == 0 {
+		hc.Retries = 3
+	}
+
+	if hc.FailureSignal == 0 {
+		hc.FailureSignal = syscall.SIGKILL
+	}
+
+	return &hc
+}
+
+// probe runs the check once and returns why it failed.
+func (s *Service) probe() error {
+	hc := s.HealthCheck
+
+	switch {
+	case hc.HTTP != nil:
+		client := http.Client{Timeout: hc.Timeout}
+		resp, err := client.Get(hc.HTTP.URL)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if hc.HTTP.ExpectedStatus != 0 && resp.StatusCode != hc.HTTP.ExpectedStatus {
+			return fmt.Errorf("GET %s: expected status %d, got %d", hc.HTTP.URL, hc.HTTP.ExpectedStatus, resp.StatusCode)
+		}
+
+		if hc.HTTP.ExpectedStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode >= 400) {
+			return fmt.Errorf("GET %s: unexpected status %d", hc.HTTP.URL, resp.StatusCode)
+		}
+
+		if hc.HTTP.ExpectedBody != "" {
+			body, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+			if err != nil {
+				return fmt.Errorf("GET %s: %w", hc.HTTP.URL, err)
+			}
+
+			if !strings.Contains(string(body), hc.HTTP.ExpectedBody) {
+				return fmt.Errorf("GET %s: body does not contain %q", hc.HTTP.URL, hc.HTTP.ExpectedBody)
+			}
+		}
+		return nil
+
+	case hc.TCP != "":
+		conn, err := net.DialTimeout("tcp", hc.TCP, hc.Timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+
+	case len(hc.Exec) > 0:
+		cmd, err := s.prepareCommand(hc.Exec)
+		if err != nil {
+			return err
+		}
+
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- cmd.Wait()
+		}()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				return fmt.Errorf("%v: %w", hc.Exec, err)
+			}
+			return nil
+		case <-time.After(hc.Timeout):
+			cmd.Process.Kill()
+			<-done
+			return fmt.Errorf("%v: timed out after %s", hc.Exec, hc.Timeout)
+		}
+
+	default:
+		return fmt.Errorf("health check has no HTTP, TCP or Exec probe")
+	}
+}
+
+func (s *Service) healthCheckGatesReadiness() bool {
+	return s.HealthCheck != nil && s.HealthCheck.Readiness
+}
+
+// waitHealthy probes inst until it passes or timeout fires. It reports false
+// without an error if the process exited first.
+func (s *Service) waitHealthy(inst *instance, timeout <-chan time.Time) (bool, error) {
+	interval := min(s.HealthCheck.Interval, 250*time.Millisecond)
+
+	for {
+		err := s.probe()
+		if err == nil {
+			s.setHealth(inst, HEALTHY, 0)
+			return true, nil
+		}
+
+		select {
+		case <-inst.done:
+			return false, nil
+		case <-timeout:
+			s.Logger.Printf("Health check failed: %v\n", err)
+			return false, ErrReadinessTimeout
+		case <-time.After(interval):
+		}
+	}
+}
+
+// monitorHealth probes inst every Interval for as long as it runs and records
+// the outcome in the service state.
+func (s *Service) monitorHealth(inst *instance) {
+	hc := s.HealthCheck
+	failures := 0
+
+	for {
+		select {
+		case <-inst.done:
+			return
+		case <-time.After(hc.Interval):
+		}
+
+		err := s.probe()
+		if inst.exited() {
+			return
+		}
+
+		if err == nil {
+			if failures > 0 || s.getCachedProcessState().Health != HEALTHY {
+				s.Logger.Println("Healthy")
+				s.setHealth(inst, HEALTHY, 0)
+			}
+			failures = 0
+			continue
+		}
+
+		if time.Since(inst.started) < hc.StartPeriod {
+			s.Logger.Printf("Health check failed during start period: %v\n", err)
+			continue
+		}
+
+		failures++
+
+		// stay quiet once the service is known to be unhealthy
+		if failures <= hc.Retries {
+			s.Logger.Printf("Health check failed (%d/%d): %v\n", failures, hc.Retries, err)
+		}
+
+		if failures < hc.Retries {
+			s.setHealth(inst, s.getCachedProcessState().Health, failures)
+			continue
+		}
+
+		if failures == hc.Retries {
+			s.Logger.Println("Unhealthy")
+		}
+		s.setHealth(inst, UNHEALTHY, failures)
+
+		if failures%hc.Retries == 0 {
+			if stop := s.handleLivenessFailure(inst, failures); stop {
+				return
+			}
+		}
+	}
+}
+
+// handleLivenessFailure runs the configured LivenessAction on inst and
+// reports whether monitoring inst should stop.
+func (s *Service) handleLivenessFailure(inst *instance, failures int) bool {
+	hc := s.HealthCheck
+
+	switch hc.OnFailure {
+	case LivenessMarkUnhealthy:
+		if failures > hc.Retries {
+			return false
+		}
+		s.Logger.Printf("Liveness failed %d times, marked unhealthy\n", failures)
+
+	case LivenessRestart:
+		s.Logger.Printf("Liveness failed %d times, restarting PID %d\n", failures, inst.pid)
+		s.countLivenessAction()
+
+		s.instanceMu.Lock()
+		s.forceRestart = true
+		s.instanceMu.Unlock()
+
+		s.stopInstance(inst)
+		return true
+
+	case LivenessSignal:
+		s.Logger.Printf("Liveness failed %d times, sending %s to PID %d\n", failures, hc.FailureSignal, inst.pid)
+		if err := inst.cmd.Process.Signal(hc.FailureSignal); err != nil {
+			s.Logger.Printf("Signal error: %v\n", err)
+		}
+
+	case LivenessHook:
+		s.Logger.Printf("Liveness failed %d times, running hook\n", failures)
+		if hc.OnFailureHook != nil {
+			hc.OnFailureHook(s)
+		}
+	}
+
+	s.countLivenessAction()
+	return false
+}
+
+func (s *Service) countLivenessAction() {
+	s.updateServiceProcess(func(sp *ServiceProcess) {
+		sp.LivenessActions++
+	})
+}
+
+// setHealth records the health of inst, unless it was replaced in the
+// meantime.
+func (s *Service) setHealth(inst *instance, health HealthStatus, failures int) {
+	s.instanceMu.Lock()
+	current := s.current == inst
+	s.instanceMu.Unlock()
+
+	if !current {
+		return
+	}
+
+	s.updateServiceProcess(func(sp *ServiceProcess) {
+		sp.Health = health
+		sp.HealthFailures = int32(failures)
+	})
+}
@@ -1,12 +1,10 @@
 package lid
 
 import (
-	"bufio"
+	"flag"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
-	"time"
 )
 
 func getExecutableDir() (string, error) {
@@ -22,6 +20,10 @@ func getExecutableDir() (string, error) {
 }
 
 func getRelativePath(relativePath string) (string, error) {
+	if filepath.IsAbs(relativePath) {
+		return relativePath, nil
+	}
+
 	// Get the executable's directory
 	execDir, err := getExecutableDir()
 	if err != nil {
@@ -42,36 +44,21 @@ func contains[T comparable](s []T, e T) bool {
 	return false
 }
 
-func tailFile(filePath string, callback func(line string) bool) error {
-	// Open the file for reading.
-	file, err := os.Open(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
-	}
-	defer file.Close()
-
-	// Seek to the end of the file.
-	_, err = file.Seek(0, io.SeekEnd)
-	if err != nil {
-		return fmt.Errorf("failed to seek to end of file: %w", err)
-	}
-
-	reader := bufio.NewReader(file)
-
+// parseArgs parses the flags in args, which may come before, after or in
+// between the positional arguments, and returns the positional arguments.
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
 	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			// Handle EOF: Wait for new data to be written.
-			if err.Error() == "EOF" {
-				time.Sleep(50 * time.Millisecond) // Polling interval.
-				continue
-			}
-			return fmt.Errorf("error reading file: %w", err)
+		if err := flags.Parse(args); err != nil {
+			return nil, err
 		}
 
-		// Print the line that was read.
-		if callback(line) {
-			return nil
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
 		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
 	}
 }
@@ -1,15 +1,18 @@
 package lid
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net/rpc"
 	"os"
 	"os/exec"
-	"sort"
+	"os/signal"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aquasecurity/table"
@@ -18,24 +21,132 @@ import (
 type Lid struct {
 	services     map[string]*Service
 	logsFilename string
+	logsDir      string
+	logRotation  LogRotation
+	logFormat    LogFormat
+	logFile      *logFile
+	stateDir     string
+	lockTimeout  time.Duration
 	logger       *log.Logger
+	supervisor   *Supervisor // set while running as `lid daemon`
+	// set in a supervisor started by ForkSpawn, which has no terminal
+	spawnReporter *spawnReporter
+	// set while a command prints its results as JSON to stdout
+	printingResults atomic.Bool
+	// services Register rejected
+	registerErrors ConfigErrors
 }
 
 type LidOptions struct {
+	// lid's own log, with the lifecycle of every service
 	LogsFilename string
+	// Directory the output of every service is written to, as <service>.log.
+	// Defaults to a "logs" directory next to LogsFilename.
+	LogsDir string
+	// How the log files are rotated, unless a service overrides it
+	LogRotation LogRotation
+	// Whether lid and the services log plain lines or JSON records
+	LogFormat LogFormat
+
+	// Directory the state of all services and the daemon socket are kept in.
+	// Defaults to $XDG_STATE_HOME/lid/<Namespace>.
+	StateDir string
+	// Name of the project, keeping its state apart from other lid binaries on
+	// the same host. Defaults to the executable name and a hash of its path.
+	Namespace string
+
+	// How long `lid start`, `lid stop` and `lid reload` wait for another lid
+	// process to finish with a service before giving up (defaults to 30s)
+	LockTimeout time.Duration
 }
 
 func NewWithOptions(options LidOptions) (*Lid, error) {
-	logFile, err := os.OpenFile(options.LogsFilename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if options.StateDir == "" {
+		options.StateDir = defaultStateDir(options.Namespace)
+	}
+
+	if err := os.MkdirAll(options.StateDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	if options.LockTimeout == 0 {
+		options.LockTimeout = 30 * time.Second
+	}
+
+	if options.LogsDir == "" {
+		options.LogsDir = filepath.Join(filepath.Dir(options.LogsFilename), "logs")
+	}
+
+	logFile, err := openLogFile(options.LogsFilename, options.LogRotation)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Lid{
-		logsFilename: options.LogsFilename,
-		logger:       log.New(logFile, "", log.Ldate|log.Ltime),
-		services:     make(map[string]*Service),
-	}, nil
+	lid := &Lid{
+		logsFilename:  options.LogsFilename,
+		logsDir:       options.LogsDir,
+		logRotation:   options.LogRotation,
+		logFormat:     options.LogFormat,
+		logFile:       logFile,
+		stateDir:      options.StateDir,
+		lockTimeout:   options.LockTimeout,
+		services:      make(map[string]*Service),
+		spawnReporter: spawnReporterFromEnv(),
+	}
+	lid.logger = lid.newLogger(logFile, "")
+
+	return lid, nil
+}
+
+// newLogger returns a logger for the messages of lid about service (or about
+// lid itself if it is empty) in the LogFormat of lid.
+func (lid *Lid) newLogger(w io.Writer, service string) *log.Logger {
+	if lid.logFormat == LogFormatJSON {
+		return newRecordLogger(w, service)
+	}
+
+	prefix := ""
+	if service != "" {
+		prefix = fmt.Sprintf("[%s] ", service)
+	}
+	return log.New(w, prefix, log.Ldate|log.Ltime)
+}
+
+// terminal wraps os.Stdout or os.Stderr, where lid and the services echo
+// their logs to, so the logs are rendered as text. A detached supervisor has
+// nobody to show them to.
+func (lid *Lid) terminal(file *os.File) io.Writer {
+	if lid.spawnReporter != nil {
+		return io.Discard
+	}
+
+	if lid.logFormat == LogFormatJSON {
+		return renderedWriter{terminalWriter{lid, file}}
+	}
+	return terminalWriter{lid, file}
+}
+
+// terminalWriter echoes to stderr instead of stdout while lid prints the
+// results of a command there.
+type terminalWriter struct {
+	lid  *Lid
+	file *os.File
+}
+
+func (t terminalWriter) Write(p []byte) (int, error) {
+	if t.file == os.Stdout && t.lid.printingResults.Load() {
+		return os.Stderr.Write(p)
+	}
+	return t.file.Write(p)
+}
+
+// newOutput returns a writer for one stream of the output of service in the
+// LogFormat of lid.
+func (lid *Lid) newOutput(w io.Writer, service, stream string) io.Writer {
+	if lid.logFormat == LogFormatJSON {
+		return newRecordWriter(w, service, stream)
+	}
+	return &timestampWriter{w: w}
 }
 
 func New() *Lid {
@@ -54,234 +165,581 @@ func New() *Lid {
 	return lid
 }
 
-func (lid *Lid) Register(serviceName string, s ServiceConfig) {
+// Register adds a service under serviceName. It returns a *ConfigError if the
+// name is taken or invalid, the Command is empty or the log files cannot be
+// opened. Run refuses to run any command but `lid check` after that. Whether
+// the service can actually be started from here is checked by Validate.
+func (lid *Lid) Register(serviceName string, s ServiceConfig) error {
+	if err := lid.register(serviceName, s); err != nil {
+		lid.registerErrors = append(lid.registerErrors, err)
+		return err
+	}
+	return nil
+}
+
+func (lid *Lid) register(serviceName string, s ServiceConfig) *ConfigError {
+	if serviceName == "" || strings.ContainsAny(serviceName, "/ \t\n") {
+		return &ConfigError{Service: serviceName, Err: ErrInvalidServiceName}
+	}
+
 	if _, ok := lid.services[serviceName]; ok {
-		log.Fatalf("Cannot register '%s' service twice.\n", serviceName)
+		return &ConfigError{Service: serviceName, Err: ErrServiceRegistered}
 	}
 
-	logFile, _ := os.OpenFile(lid.logsFilename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if len(s.Command) == 0 {
+		return &ConfigError{Service: serviceName, Field: "Command", Err: ErrEmptyCommand}
+	}
 
-	if s.Logger == nil {
-		logger := log.New(io.MultiWriter(os.Stdout, logFile), fmt.Sprintf("[%s] ", serviceName), log.Ldate|log.Ltime)
+	if s.ExitCommand != nil && len(s.ExitCommand) == 0 {
+		return &ConfigError{Service: serviceName, Field: "ExitCommand", Err: ErrEmptyCommand}
+	}
+
+	if s.LogFile == "" {
+		s.LogFile = filepath.Join(lid.logsDir, serviceName+".log")
+	}
+
+	if s.ErrLogFile == "" {
+		s.ErrLogFile = s.LogFile
 
-		if s.Stdout == nil {
-			s.Stdout = logger.Writer()
+		// only records tell the streams apart in a shared file
+		if lid.logFormat == LogFormatText {
+			s.ErrLogFile = errLogFilename(s.LogFile)
 		}
+	}
+
+	if s.LogRotation == nil {
+		s.LogRotation = &lid.logRotation
+	}
 
-		if s.Stderr == nil {
-			s.Stderr = logger.Writer()
+	if s.Logger == nil {
+		s.Logger = lid.newLogger(io.MultiWriter(lid.terminal(os.Stdout), lid.logFile), serviceName)
+
+		if s.Stdout == nil || s.Stderr == nil {
+			output, err := openLogFile(s.LogFile, *s.LogRotation)
+			if err != nil {
+				return &ConfigError{Service: serviceName, Field: "LogFile", Err: err}
+			}
+
+			errOutput := output
+			if s.ErrLogFile != s.LogFile {
+				errOutput, err = openLogFile(s.ErrLogFile, *s.LogRotation)
+				if err != nil {
+					return &ConfigError{Service: serviceName, Field: "ErrLogFile", Err: err}
+				}
+			}
+
+			if s.Stdout == nil {
+				s.Stdout = lid.newOutput(io.MultiWriter(lid.terminal(os.Stdout), output), serviceName, STREAM_STDOUT)
+			}
+
+			if s.Stderr == nil {
+				s.Stderr = lid.newOutput(io.MultiWriter(lid.terminal(os.Stderr), errOutput), serviceName, STREAM_STDERR)
+			}
 		}
+	}
+
+	if s.StateDir == "" {
+		s.StateDir = lid.stateDir
+	}
 
-		s.Logger = logger
+	service := NewService(serviceName, s)
+	if migrated, err := service.migrateLegacyState(); err != nil {
+		lid.logger.Printf("Failed to migrate legacy state of '%s': %v\n", serviceName, err)
+	} else if migrated {
+		lid.logger.Printf("Migrated legacy state of '%s' to %s\n", serviceName, service.GetServiceProcessFilename())
 	}
 
-	lid.services[serviceName] = NewService(serviceName, s)
+	lid.services[serviceName] = service
+	return nil
 }
 
-func (lid *Lid) ForkSpawn(serviceName string) {
+// ForkSpawn starts the supervisor of a service as a detached `spawn`
+// process and waits for it to report whether the service became ready.
+func (lid *Lid) ForkSpawn(serviceName string) error {
 	service, ok := lid.services[serviceName]
 	if !ok {
-		lid.logger.Printf("Service '%s' not found\n", serviceName)
-		return
+		return fmt.Errorf("service '%s' not found", serviceName)
 	}
 
-	// exe
-	executablePath, _ := os.Executable()
+	executablePath, err := os.Executable()
+	if err != nil {
+		return err
+	}
 	cmd := exec.Command(executablePath, "spawn", serviceName)
 
-	// temp process indpendent file
-	tempFile, err := os.CreateTemp("", "lid-spawn-")
-
+	// the supervisor reports the outcome of the start on its fd 3, which it
+	// closes on exec, so the end of the pipe means it is gone
+	events, eventsWriter, err := os.Pipe()
 	if err != nil {
-		service.Logger.Printf("Failed to create temp file: %v\n", err)
-		return
+		return err
 	}
+	defer events.Close()
 
-	// Remove the temp file when done
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
-
-	cmd.Stdout = tempFile
-	cmd.Stderr = tempFile
+	cmd.ExtraFiles = []*os.File{eventsWriter}
+	cmd.Env = append(os.Environ(), SPAWN_FD_ENV+"=3")
 
-	// Start command in background
-	if err := cmd.Start(); err != nil {
-		service.Logger.Printf("Failed to start service: %v\n", err)
-		return
+	// the supervisor writes its logs itself, this only catches crashes
+	output, err := os.OpenFile(lid.logsFilename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		eventsWriter.Close()
+		return err
 	}
+	cmd.Stdout = output
+	cmd.Stderr = output
 
-	readyChan := make(chan bool)
 	start := time.Now()
-	timeout := time.After(service.ReadinessCheckTimeout)
+	err = cmd.Start()
+	eventsWriter.Close()
+	output.Close()
+	if err != nil {
+		return fmt.Errorf("failed to start the supervisor: %w", err)
+	}
 
-	go tailFile(tempFile.Name(), func(line string) bool {
-		// fmt.Print("\t", line)
+	// Detach the process
+	defer cmd.Process.Release()
 
-		service.Logger.Printf("%s", line)
+	if err := awaitSpawn(service, events); err != nil {
+		return err
+	}
 
-		if strings.Contains(line, READINESS_CHECK_PASSED_MESSAGE) || strings.Contains(line, NO_READINESS_CHECK_MESSAGE) {
-			readyChan <- true
-			return true
-		}
-		return false
-	})
+	service.Logger.Printf("Started successfully in %s\n", time.Since(start))
+	return nil
+}
 
-	// wait for "Readiness check passed" with timeout
-	select {
-	case <-readyChan:
-		service.Logger.Printf("Started successfully in %s\n", time.Since(start))
-	case <-timeout:
-		service.Logger.Printf("Warning: Service is taking longer than %.2f second(s) to start. Consider configuring the service's 'ReadinessCheckTimeout'.", service.ReadinessCheckTimeout.Seconds())
+// spawn starts the supervisor of a service, inside the daemon if this is
+// one, or as a detached process otherwise.
+func (lid *Lid) spawn(service *Service) error {
+	if lid.supervisor != nil {
+		return lid.supervisor.spawn(service)
 	}
+	return lid.ForkSpawn(service.Name)
+}
 
-	// Detach the process
-	cmd.Process.Release()
-	service.Logger.Printf("Detached process\n")
+// Start starts the given services (or all of them) together with their
+// dependencies. A service is only started once all of its dependencies are
+// running. It returns an ErrStartFailed naming the services that did not
+// start.
+func (lid *Lid) Start(services []string) error {
+	return resultsError(ErrStartFailed, lid.StartServices(services))
 }
 
-func (lid *Lid) Start(services []string) {
-	var wg sync.WaitGroup
+// StartServices is Start returning what it did to every service, in the
+// order of their names.
+func (lid *Lid) StartServices(services []string) []ServiceResult {
+	names := lid.withDependencies(services)
 
-	for _, service := range lid.services {
-		if len(services) > 0 {
-			if !contains(services, service.Name) {
-				continue
-			}
-		}
+	type startResult struct {
+		done   chan struct{}
+		result ServiceResult
+	}
+
+	results := make(map[string]*startResult, len(names))
+	for _, name := range names {
+		results[name] = &startResult{done: make(chan struct{})}
+	}
+
+	var wg sync.WaitGroup
+	for _, name := range names {
+		service := lid.services[name]
+		result := results[name]
+		explicit := contains(services, name)
 
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			proc, err := service.GetRunningProcess()
-			if err == nil {
-				service.Logger.Printf("Running with PID %d\n", proc.Pid)
-			} else {
-				lid.ForkSpawn(service.Name)
+			defer close(result.done)
+
+			for _, dep := range service.DependsOn {
+				<-results[dep].done
+				if !results[dep].result.running() {
+					service.Logger.Printf("Not starting, dependency '%s' is not running\n", dep)
+					result.result = ServiceResult{
+						Outcome: OUTCOME_SKIPPED,
+						Error:   fmt.Sprintf("dependency '%s' is not running", dep),
+					}
+					break
+				}
 			}
+
+			if result.result.Outcome == "" {
+				result.result = lid.startService(service, explicit)
+			}
+			result.result.Name = name
+			result.result.Action = "start"
+			result.result.Explicit = explicit
 		}()
 	}
 
 	wg.Wait()
+
+	started := make([]ServiceResult, 0, len(names))
+	for _, name := range names {
+		started = append(started, results[name].result)
+	}
+	return started
 }
 
+// running reports whether the service runs after a start.
+func (r ServiceResult) running() bool {
+	return r.Outcome == OUTCOME_STARTED || r.Outcome == OUTCOME_ALREADY_RUNNING
+}
+
+// startService starts a single service unless it is already running. FATAL
+// services are only started when they were asked for explicitly.
+func (lid *Lid) startService(service *Service, explicit bool) (result ServiceResult) {
+	start := time.Now()
+	defer func() {
+		result.Duration = time.Since(start)
+	}()
+
+	lock, err := service.lockOperation(lid.lockTimeout)
+	if err != nil {
+		service.Logger.Printf("Not starting: %v\n", err)
+		return failedResult(err)
+	}
+	defer lock.Unlock()
+
+	if service.GetCachedStatus() == FATAL {
+		if !explicit {
+			service.Logger.Println("Skipping FATAL service, start it explicitly to clear the state")
+			return ServiceResult{Outcome: OUTCOME_SKIPPED, Error: "service is FATAL, start it explicitly to clear the state"}
+		}
+
+		service.Logger.Println("Clearing FATAL state")
+		service.ClearFatal()
+	}
+
+	// a second supervisor could not take over the service anyway
+	if service.GetCachedStatus() == BACKOFF && service.supervisorRunning() {
+		return supervisedResult(service)
+	}
+
+	proc, err := service.GetRunningProcess()
+	if err != nil {
+		if err := lid.spawn(service); errors.Is(err, ErrServiceSupervised) {
+			return supervisedResult(service)
+		} else if err != nil {
+			service.Logger.Printf("%v\n", err)
+			return ServiceResult{Outcome: OUTCOME_FAILED, Error: err.Error()}
+		}
+
+		// the supervisor reported the service ready, even if it crashed
+		// right after
+		return ServiceResult{Outcome: OUTCOME_STARTED, Pid: service.GetPid()}
+	}
+
+	service.Logger.Printf("Running with PID %d\n", proc.Pid)
+	if !waitUntilRunning(service, service.ReadinessCheckTimeout) {
+		return ServiceResult{Outcome: OUTCOME_FAILED, Error: fmt.Sprintf("service is %s", service.GetCachedStatus())}
+	}
+	return ServiceResult{Outcome: OUTCOME_ALREADY_RUNNING, Pid: proc.Pid}
+}
+
+// supervisedResult is the result of starting a service that a supervisor
+// already looks after: it is running, about to be, or waiting to be
+// restarted.
+func supervisedResult(service *Service) ServiceResult {
+	if service.GetCachedStatus() == BACKOFF {
+		service.Logger.Println("Backing off, its supervisor restarts it")
+		return ServiceResult{Outcome: OUTCOME_BACKING_OFF}
+	}
+
+	if !waitUntilRunning(service, service.ReadinessCheckTimeout) {
+		return ServiceResult{Outcome: OUTCOME_FAILED, Error: fmt.Sprintf("service is %s", service.GetCachedStatus())}
+	}
+	return ServiceResult{Outcome: OUTCOME_ALREADY_RUNNING, Pid: service.GetPid()}
+}
+
+// failedResult is the result of an operation that failed with err.
+func failedResult(err error) ServiceResult {
+	if errors.Is(err, ErrServiceLocked) {
+		return ServiceResult{Outcome: OUTCOME_LOCKED, Error: err.Error()}
+	}
+	return ServiceResult{Outcome: OUTCOME_FAILED, Error: err.Error()}
+}
+
+// Stop stops the given services (or all of them). A service is only stopped
+// once every service depending on it has been stopped.
 func (lid *Lid) Stop(services []string) {
+	lid.StopServices(services)
+}
+
+// StopServices is Stop returning what it did to every service, in the order
+// of their names.
+func (lid *Lid) StopServices(services []string) []ServiceResult {
 	lid.logger.Println("Stopping services")
-	var wg sync.WaitGroup
 
-	for _, service := range lid.services {
-		if len(services) > 0 {
-			if !contains(services, service.Name) {
-				continue
-			}
-		}
+	names := lid.selectServices(services)
+	dependents := lid.dependents(names)
+
+	stopped := make(map[string]chan struct{}, len(names))
+	for _, name := range names {
+		stopped[name] = make(chan struct{})
+	}
+
+	results := make([]ServiceResult, len(names))
+
+	var wg sync.WaitGroup
+	for i, name := range names {
+		service := lid.services[name]
 
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			err := service.Stop()
-			if err != nil {
-				service.Logger.Printf("%s: %v\n", service.Name, err)
-			} else {
-				service.Logger.Printf("%s: Stopped\n", service.Name)
+			defer close(stopped[name])
+
+			for _, dependent := range dependents[name] {
+				<-stopped[dependent]
 			}
+
+			results[i] = lid.stopService(service)
+			results[i].Name = name
+			results[i].Action = "stop"
+			results[i].Explicit = contains(services, name)
 		}()
 	}
 
 	wg.Wait()
 	lid.logger.Println("Services stopped")
+	return results
 }
 
-func (lid *Lid) List() {
-	t := table.New(os.Stdout)
+func (lid *Lid) stopService(service *Service) (result ServiceResult) {
+	start := time.Now()
+	defer func() {
+		result.Duration = time.Since(start)
+	}()
+
+	lock, err := service.lockOperation(lid.lockTimeout)
+	if err != nil {
+		service.Logger.Printf("Not stopping: %v\n", err)
+		return failedResult(err)
+	}
+	defer lock.Unlock()
+
+	pid := service.GetPid()
+	err = service.Stop()
+	if errors.Is(err, ErrServiceDown) {
+		service.Logger.Printf("%s: %v\n", service.Name, err)
+		return ServiceResult{Outcome: OUTCOME_NOT_RUNNING}
+	} else if err != nil {
+		service.Logger.Printf("%s: %v\n", service.Name, err)
+		return ServiceResult{Outcome: OUTCOME_FAILED, Pid: pid, Error: err.Error()}
+	}
+
+	service.Logger.Printf("%s: Stopped\n", service.Name)
+	return ServiceResult{Outcome: OUTCOME_STOPPED, Pid: pid}
+}
+
+// Restart stops and then starts the given services (or all of them) and
+// returns what it did to every service.
+func (lid *Lid) Restart(services []string) []ServiceResult {
+	return restartResults(lid.StopServices(services), lid.StartServices(services))
+}
+
+func (lid *Lid) Reload(services []string) {
+	lid.ReloadServices(services)
+}
 
-	t.SetHeaders("Name", "Status", "Uptime", "PID", "CPU", "Memory")
+// ReloadServices is Reload returning what it did to every service, in the
+// order of their names.
+func (lid *Lid) ReloadServices(services []string) []ServiceResult {
+	names := lid.selectServices(services)
+	results := make([]ServiceResult, len(names))
 
-	keys := make([]string, 0, len(lid.services))
-	for key := range lid.services {
-		keys = append(keys, key)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		service := lid.services[name]
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			results[i] = lid.reloadService(service)
+			results[i].Name = name
+			results[i].Action = "reload"
+			results[i].Explicit = contains(services, name)
+		}()
 	}
-	sort.Strings(keys)
-	// for _, service := range lid.services {
-	for _, serviceName := range keys {
-		service := lid.services[serviceName]
-		proc, err := service.GetRunningProcess()
 
+	wg.Wait()
+	return results
+}
+
+func (lid *Lid) reloadService(service *Service) (result ServiceResult) {
+	start := time.Now()
+	defer func() {
+		result.Duration = time.Since(start)
+	}()
+
+	lock, err := service.lockOperation(lid.lockTimeout)
+	if err != nil {
+		service.Logger.Printf("Not reloading: %v\n", err)
+		return failedResult(err)
+	}
+	defer lock.Unlock()
+
+	if !service.IsRunning() {
+		service.Logger.Println("Not running, starting instead")
+		if err := lid.spawn(service); err != nil {
+			service.Logger.Printf("%v\n", err)
+			return ServiceResult{Outcome: OUTCOME_FAILED, Error: err.Error()}
+		}
+		return ServiceResult{Outcome: OUTCOME_STARTED, Pid: service.GetPid()}
+	}
+
+	if lid.supervisor != nil && lid.supervisor.owns(service.Name) {
+		err = service.Reload()
+	} else {
+		err = service.requestReload()
+	}
+
+	if err != nil {
+		service.Logger.Printf("Reload failed: %v\n", err)
+		return failedResult(err)
+	}
+	return ServiceResult{Outcome: OUTCOME_RELOADED, Pid: service.GetPid()}
+}
+
+// ServiceInfo is a snapshot of a service as shown by `lid list` and
+// `lid status`. See MarshalJSON for how --json prints it.
+type ServiceInfo struct {
+	Name           string
+	Status         ServiceStatus
+	Pid            int32
+	StartTime      time.Time // Zero unless the service runs
+	Uptime         time.Duration
+	CPU            float64
+	MemoryRSS      uint64
+	Restarts       int32
+	ExitCode       int32
+	HasExited      bool // ExitCode is only meaningful once the service exited
+	Health         HealthStatus
+	HasHealthCheck bool
+
+	Command  []string
+	Cwd      string   // The directory the command runs in
+	EnvFiles []string // Ending in "?" if optional
+}
+
+// Statuses returns a snapshot of the given services (or all of them), sorted
+// by name.
+func (lid *Lid) Statuses(services []string) []ServiceInfo {
+	names := lid.selectServices(services)
+	infos := make([]ServiceInfo, 0, len(names))
+
+	for _, name := range names {
+		service := lid.services[name]
+		state := service.getCachedProcessState()
+		cwd, _ := service.workDir()
+
+		info := ServiceInfo{
+			Name:           name,
+			Status:         state.Status,
+			Restarts:       state.Restarts,
+			ExitCode:       state.ExitCode,
+			HasExited:      state.Status == EXITED || state.Status == FATAL || state.Restarts > 0,
+			Health:         state.Health,
+			HasHealthCheck: service.HealthCheck != nil,
+			Command:        service.Command,
+			Cwd:            cwd,
+			EnvFiles:       service.envFilePaths(),
+		}
+
+		proc, err := service.GetRunningProcess()
 		if err != nil {
-			t.AddRow(service.Name, "\033[31mStopped\033[0m", "0", "-", "-")
+			if info.Status != EXITED && info.Status != BACKOFF && info.Status != FATAL {
+				info.Status = STOPPED
+			}
+			infos = append(infos, info)
 			continue
 		}
 
 		createTime, _ := proc.CreateTime()
-		upTime := time.Now().UnixMilli() - createTime
-		cpu, _ := proc.CPUPercent()
-		mem, _ := proc.MemoryInfo()
-		pid := proc.Pid
-
-		status := service.GetCachedStatus()
-		statusStr := ""
-		if status == STARTING {
-			statusStr = "\033[33mStarting\033[0m"
-		} else if status == RUNNING {
-			statusStr = "\033[32mRunning\033[0m"
-		} else if status == STOPPED {
-			statusStr = "\033[31mStopped\033[0m"
+		info.StartTime = time.UnixMilli(createTime)
+		info.Uptime = time.Since(info.StartTime)
+		info.CPU, _ = proc.CPUPercent()
+		if mem, err := proc.MemoryInfo(); err == nil {
+			info.MemoryRSS = mem.RSS
 		}
+		info.Pid = proc.Pid
 
-		t.AddRow(
-			service.Name,
-			statusStr,
-			fmt.Sprintf("%ds", upTime/1000),
-			fmt.Sprintf("%d", pid),
-			fmt.Sprintf("%f%%", cpu),
-			fmt.Sprintf("%.2fMB", float64(mem.RSS)/1024/1024),
-		)
+		infos = append(infos, info)
 	}
 
-	t.Render()
+	return infos
 }
 
-// func (lid *Lid) Logs(services []string) {
-// 	log.Printf("Tailing file %s\n", lid.logsFilename)
-// 	cmd := exec.Command("tail", "-n", "20", "-f", lid.logsFilename)
-// 	cmd.Stdout = os.Stdout
-// 	cmd.Stderr = os.Stderr
-// 	cmd.Run()
-// }
+func (lid *Lid) List() {
+	renderStatuses(lid.Statuses(nil))
+}
 
-func (lid *Lid) Logs(services []string) {
-	file, err := os.Open(lid.logsFilename)
-	if err != nil {
-		lid.logger.Printf("Failed to open logs: %v", err)
-		return
-	}
-	defer file.Close()
+func renderStatuses(infos []ServiceInfo) {
+	t := table.New(os.Stdout)
 
-	// Seek to end and follow
-	file.Seek(0, io.SeekEnd)
-	reader := bufio.NewReader(file)
+	t.SetHeaders("Name", "Status", "Uptime", "PID", "CPU", "Memory", "Restarts", "Last Exit", "Health")
 
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err != io.EOF {
-				lid.logger.Printf("Read error: %v", err)
-				return
-			}
-			time.Sleep(500 * time.Millisecond)
+	for _, info := range infos {
+		restarts := fmt.Sprintf("%d", info.Restarts)
+		lastExit := "-"
+		if info.HasExited {
+			lastExit = fmt.Sprintf("%d", info.ExitCode)
+		}
+
+		if info.Pid == NO_PID {
+			t.AddRow(info.Name, statusLabel(info.Status), "0", "-", "-", "-", restarts, lastExit, "-")
 			continue
 		}
 
-		// Filter by service if specified
-		if len(services) > 0 {
-			for _, service := range services {
-				if strings.Contains(line, fmt.Sprintf("[%s]", service)) {
-					fmt.Print(line)
-					break
-				}
-			}
-		} else {
-			fmt.Print(line)
+		health := "-"
+		if info.HasHealthCheck {
+			health = healthLabel(info.Health)
 		}
+
+		t.AddRow(
+			info.Name,
+			statusLabel(info.Status),
+			fmt.Sprintf("%ds", int64(info.Uptime.Seconds())),
+			fmt.Sprintf("%d", info.Pid),
+			fmt.Sprintf("%f%%", info.CPU),
+			fmt.Sprintf("%.2fMB", float64(info.MemoryRSS)/1024/1024),
+			restarts,
+			lastExit,
+			health,
+		)
+	}
+
+	t.Render()
+}
+
+// statusLabel renders a status in its own color for the terminal.
+func statusLabel(status ServiceStatus) string {
+	switch status {
+	case STARTING:
+		return "\033[33mStarting\033[0m"
+	case RUNNING:
+		return "\033[32mRunning\033[0m"
+	case STOPPING:
+		return "\033[33mStopping\033[0m"
+	case EXITED:
+		return "\033[31mExited\033[0m"
+	case BACKOFF:
+		return "\033[35mBackoff\033[0m"
+	case FATAL:
+		return "\033[1;97;41mFatal\033[0m"
+	default:
+		return "\033[31mStopped\033[0m"
+	}
+}
+
+func healthLabel(health HealthStatus) string {
+	switch health {
+	case HEALTHY:
+		return "\033[32mHealthy\033[0m"
+	case UNHEALTHY:
+		return "\033[31mUnhealthy\033[0m"
+	default:
+		return "\033[33mUnknown\033[0m"
 	}
 }
 
@@ -293,16 +751,39 @@ Usage:
 
 Available commands:
 	list			Lists the status of all services
+	list <service>...	Lists the status of specific services
+	status <service>	Shows the command, cwd, env files, PID, start time, restarts, last exit and health of a service
+	env <service>		Prints the environment a service is started with, with secrets masked (--show-secrets to reveal them)
+	check [service...]	Reports every problem with the config of all (or specific) services, e.g. missing programs or env files
+	apply			Starts added services, restarts changed ones and stops removed ones, after printing the plan
+	apply --dry-run		Only prints the plan
 	start			Starts all registered services
 	start <service>		Starts a specific service
 	stop			Stops all running services
 	stop <service>		Stops a specific service
 	restart 		Restarts all services
 	restart <service>	Restarts a specific service
+	reload			Reloads all services without downtime
+	reload <service>	Starts a new instance of a service and stops the old one once the new one is ready
+	<command> --json	Prints a JSON object per service (list, status, start, stop, restart and reload)
+	<command> --format <template>	Prints every service with a Go template instead, e.g. '{{.Name}} {{.Status}}'
 	logs				Tails the logs of all services
-	logs <service>		Tails the logs of a specific service
+	logs <service>...	Tails the logs of specific services
+	logs -n <lines>		Shows the last lines written before tailing
+	logs --since <time>	Shows the lines written since then, e.g. 10m or "2006-01-02 15:04" (or --until)
+	logs --grep <regexp>	Only shows the lines matching the regexp
+	logs --no-follow	Exits once the lines written so far were shown
+	logs --stderr-only	Only tails what the services write to stderr
+	daemon			Runs a long-lived supervisor the other commands talk to
 	spawn <service>		Spawns and attaches to the service. Meant for debugging
 
+Exit codes:
+	0	Success
+	1	A service failed to start, stop, reload or apply, check: a problem was found
+	2	Unknown command, flag or service
+	3	stop: a service named was not running, list: a service exited, is backing off or is fatal, status: the service is not running
+	4	Another lid process kept a service busy for too long
+
 Available services:
 `
 
@@ -320,29 +801,75 @@ Available services:
 func (lid *Lid) Run() {
 	log.SetFlags(0)
 	if len(os.Args) < 2 {
-		log.Fatal(lid.GetUsage())
+		log.Print(lid.GetUsage())
+		os.Exit(EXIT_USAGE)
+	}
+
+	// `lid check` reports them with everything else
+	if os.Args[1] != "check" {
+		if len(lid.registerErrors) > 0 {
+			log.Fatalln(lid.registerErrors)
+		}
+
+		if err := lid.CheckDependencies(); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	var client *rpc.Client
+	if os.Args[1] != "daemon" && os.Args[1] != "spawn" {
+		client = lid.dialDaemon()
+		if client != nil {
+			defer client.Close()
+		}
 	}
 
 	switch os.Args[1] {
-	case "start":
-		lid.Start(os.Args[2:])
-	case "stop":
-		lid.Stop(os.Args[2:])
-	case "restart":
-		lid.Stop(os.Args[2:])
-		lid.Start(os.Args[2:])
-	case "list", "ls":
-		lid.List()
+	case "daemon":
+		daemon, err := NewDaemon(lid)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		if err := daemon.Run(); err != nil {
+			log.Fatalln(err)
+		}
+	case "start", "stop", "restart", "reload", "list", "ls", "status":
+		lid.runCommand(client, os.Args[1], os.Args[2:])
+	case "env":
+		lid.runEnv(os.Args[2:])
+	case "check":
+		lid.runCheck(os.Args[2:])
+	case "apply":
+		lid.runApply(client, os.Args[2:])
 	case "logs":
-		lid.Logs(os.Args[2:])
+		if client != nil {
+			lid.useDaemonLogs(client)
+		}
+		lid.runLogs(os.Args[2:])
 	case "spawn":
 		serviceName := os.Args[2]
+		service := lid.services[serviceName]
+
+		// `lid reload` asks this process to hand over to a new instance
+		reloads := make(chan os.Signal, 1)
+		signal.Notify(reloads, RELOAD_SIGNAL)
+		go func() {
+			for range reloads {
+				service.Reload()
+			}
+		}()
+
+		service.setSpawnReporter(lid.spawnReporter)
+
 		lid.logger.Printf("Starting %s\n", serviceName)
-		err := lid.services[serviceName].Start()
+		err := service.Start()
 		if err != nil {
 			lid.logger.Printf("Could not start %s: %v\n", serviceName, err)
+			lid.spawnReporter.report(spawnEvent{Type: SPAWN_FAILED, Reason: err.Error()})
 		}
 	default:
-		log.Fatal(lid.GetUsage())
+		log.Print(lid.GetUsage())
+		os.Exit(EXIT_USAGE)
 	}
 }
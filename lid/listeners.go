@@ -0,0 +1,93 @@
+package lid
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// LISTEN_FDS_START is the first file descriptor listeners are passed on,
+// following the systemd socket activation convention.
+const LISTEN_FDS_START = 3
+
+// parseListenAddress splits "tcp://:8080" or "unix:///run/app.sock" into a
+// network and an address for net.Listen.
+func parseListenAddress(listener string) (string, string, error) {
+	network, address, ok := strings.Cut(listener, "://")
+	if !ok || address == "" {
+		return "", "", fmt.Errorf("invalid listener %q, expected <network>://<address>", listener)
+	}
+
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return network, address, nil
+	default:
+		return "", "", fmt.Errorf("invalid listener %q, unsupported network %q", listener, network)
+	}
+}
+
+// bindListeners binds the service's Listeners. They stay open for as long as
+// Start runs so every process of the service shares the same sockets.
+func (s *Service) bindListeners() error {
+	for _, listener := range s.Listeners {
+		network, address, err := parseListenAddress(listener)
+		if err != nil {
+			s.closeListeners()
+			return err
+		}
+
+		if network == "unix" {
+			// a previous run might have left its socket behind
+			os.Remove(address)
+		}
+
+		l, err := net.Listen(network, address)
+		if err != nil {
+			s.closeListeners()
+			return fmt.Errorf("failed to listen on %s: %w", listener, err)
+		}
+
+		file, err := l.(interface{ File() (*os.File, error) }).File()
+		if err != nil {
+			l.Close()
+			s.closeListeners()
+			return fmt.Errorf("failed to get file of listener %s: %w", listener, err)
+		}
+
+		s.Logger.Printf("Listening on %s\n", l.Addr())
+		s.listeners = append(s.listeners, l)
+		s.listenerFiles = append(s.listenerFiles, file)
+	}
+
+	return nil
+}
+
+func (s *Service) closeListeners() {
+	for _, file := range s.listenerFiles {
+		file.Close()
+	}
+
+	for _, l := range s.listeners {
+		l.Close()
+	}
+
+	s.listenerFiles = nil
+	s.listeners = nil
+}
+
+// passListeners hands the bound listeners to cmd as file descriptors 3, 4, ...
+// and sets LISTEN_FDS and LISTEN_PID. LISTEN_PID has to be the pid of the
+// service, which is only known after the fork, so the command is wrapped in a
+// shell that sets it and execs into the command, keeping its pid.
+func (s *Service) passListeners(cmd *exec.Cmd) {
+	if len(s.listenerFiles) == 0 {
+		return
+	}
+
+	cmd.ExtraFiles = s.listenerFiles
+	cmd.Env = append(cmd.Env, fmt.Sprintf("LISTEN_FDS=%d", len(s.listenerFiles)))
+	cmd.Args = append([]string{"/bin/sh", "-c", `export LISTEN_PID=$$; exec "$@"`, "lid-listen", cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = "/bin/sh"
+}
@@ -0,0 +1,118 @@
+package lid
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// fileLock is an advisory lock (flock) shared by every lid process on the
+// host. Locks are tied to their open file, so two goroutines of one process
+// exclude each other as well.
+type fileLock struct {
+	file *os.File
+}
+
+// lockFile takes an exclusive lock on filename. A negative timeout waits for
+// as long as it takes, a timeout of 0 fails right away and any other timeout
+// waits at most that long. It returns ErrServiceLocked if the lock is held
+// elsewhere.
+func lockFile(filename string, timeout time.Duration) (*fileLock, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout < 0 {
+		if err := flock(file, syscall.LOCK_EX); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &fileLock{file: file}, nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err := flock(file, syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return &fileLock{file: file}, nil
+		}
+
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			file.Close()
+			return nil, err
+		}
+
+		if time.Now().After(deadline) {
+			file.Close()
+			return nil, ErrServiceLocked
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func flock(file *os.File, how int) error {
+	for {
+		err := syscall.Flock(int(file.Fd()), how)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+func (l *fileLock) Unlock() {
+	syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	l.file.Close()
+}
+
+func (s *Service) lockFilename(kind string) string {
+	return filepath.Join(s.StateDir, fmt.Sprintf("service-%s.%s.lock", s.Name, kind))
+}
+
+// lockState serializes read-modify-write cycles of the state file.
+func (s *Service) lockState() (*fileLock, error) {
+	return lockFile(s.lockFilename("state"), -1)
+}
+
+// lockSupervisor makes sure only one process runs the Start loop of the
+// service. It is held for as long as the loop runs and fails right away if
+// another process holds it. Hooks like OnExit may call Start from within
+// Start, so the Service holding it can take it again.
+func (s *Service) lockSupervisor() (unlock func(), err error) {
+	s.instanceMu.Lock()
+	defer s.instanceMu.Unlock()
+
+	if s.supervisorLock == nil {
+		lock, err := lockFile(s.lockFilename("supervisor"), 0)
+		if err != nil {
+			return nil, err
+		}
+		s.supervisorLock = lock
+	}
+	s.supervisorDepth++
+
+	return func() {
+		s.instanceMu.Lock()
+		defer s.instanceMu.Unlock()
+
+		s.supervisorDepth--
+		if s.supervisorDepth == 0 {
+			s.supervisorLock.Unlock()
+			s.supervisorLock = nil
+		}
+	}, nil
+}
+
+// lockOperation serializes `lid start` and `lid stop` of the service across
+// lid processes, waiting at most timeout for another one to finish.
+func (s *Service) lockOperation(timeout time.Duration) (*fileLock, error) {
+	lock, err := lockFile(s.lockFilename("operation"), timeout)
+	if errors.Is(err, ErrServiceLocked) {
+		return nil, fmt.Errorf("%w: '%s' is still being started or stopped after %s", ErrServiceLocked, s.Name, timeout)
+	}
+	return lock, err
+}
@@ -0,0 +1,332 @@
+package lid
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogRotation configures when a log file is rotated and how many rotated
+// files are kept. A file is rotated once it exceeds MaxSize or its current
+// segment is older than MaxAge, whichever comes first.
+type LogRotation struct {
+	MaxSize    int64         // Bytes a file may grow to (defaults to 10MB, negative disables)
+	MaxAge     time.Duration // Time since the last rotation (0 disables)
+	MaxBackups int           // Rotated files to keep (defaults to 5, negative keeps all)
+	Compress   bool          // Gzip rotated files
+}
+
+func (r LogRotation) withDefaults() LogRotation {
+	if r.MaxSize == 0 {
+		r.MaxSize = 10 * 1024 * 1024
+	}
+
+	if r.MaxBackups == 0 {
+		r.MaxBackups = 5
+	}
+
+	return r
+}
+
+// LOG_ROTATION_TIME_FORMAT is appended to the name of a rotated log file, so
+// sorting the names sorts them by age.
+const LOG_ROTATION_TIME_FORMAT = "20060102-150405.000"
+
+// logFile appends to a file that is rotated according to a LogRotation. Every
+// lid process writing to the same file rotates it under a lock and follows
+// rotations done by the others.
+type logFile struct {
+	mu       sync.Mutex
+	filename string
+	rotation LogRotation
+
+	file         *os.File
+	size         int64
+	segmentStart time.Time
+	lastCheck    time.Time
+}
+
+// errLogFilename names the file stderr goes to next to logFile when it is not
+// shared, e.g. "api.err.log" for "api.log".
+func errLogFilename(logFile string) string {
+	ext := filepath.Ext(logFile)
+	return strings.TrimSuffix(logFile, ext) + ".err" + ext
+}
+
+// OpenLogFile opens filename for appending, rotating it according to
+// rotation. Lid.Register uses it for the output of every service, use it for
+// the Stdout or Stderr of a service to rotate a log file of your own.
+func OpenLogFile(filename string, rotation LogRotation) (io.WriteCloser, error) {
+	return openLogFile(filename, rotation)
+}
+
+func openLogFile(filename string, rotation LogRotation) (*logFile, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, err
+	}
+
+	l := &logFile{
+		filename: filename,
+		rotation: rotation.withDefaults(),
+	}
+
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *logFile) open() error {
+	file, err := os.OpenFile(l.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	if l.file != nil {
+		l.file.Close()
+	}
+
+	l.file = file
+	l.size = info.Size()
+	l.lastCheck = time.Now()
+	l.segmentStart = time.Now()
+
+	// the current segment started with the last rotation
+	if backups := l.backups(); len(backups) > 0 {
+		if rotated, ok := rotationTime(l.filename, backups[len(backups)-1]); ok {
+			l.segmentStart = rotated
+		}
+	} else if info.Size() > 0 {
+		l.segmentStart = info.ModTime()
+	}
+
+	return nil
+}
+
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// notice rotations by other processes every now and then
+	if time.Since(l.lastCheck) > time.Second {
+		if err := l.refresh(); err != nil {
+			return 0, err
+		}
+	}
+
+	if l.shouldRotate(int64(len(p))) {
+		if err := l.rotate(int64(len(p))); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to rotate %s: %v\n", l.filename, err)
+		}
+	}
+
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+func (l *logFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// refresh reopens the file if another process rotated it and picks up the
+// size it grew to in the meantime.
+func (l *logFile) refresh() error {
+	l.lastCheck = time.Now()
+
+	current, err := l.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(l.filename)
+	if err != nil || !os.SameFile(info, current) {
+		return l.open()
+	}
+
+	l.size = current.Size()
+	return nil
+}
+
+func (l *logFile) shouldRotate(writing int64) bool {
+	if l.rotation.MaxSize > 0 && l.size > 0 && l.size+writing > l.rotation.MaxSize {
+		return true
+	}
+
+	return l.rotation.MaxAge > 0 && l.size > 0 && time.Since(l.segmentStart) > l.rotation.MaxAge
+}
+
+func (l *logFile) rotate(writing int64) error {
+	lock, err := lockFile(l.filename+".lock", -1)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	// another process might have rotated the file while we waited
+	if err := l.refresh(); err != nil {
+		return err
+	}
+	if !l.shouldRotate(writing) {
+		return nil
+	}
+
+	backup := l.filename + "." + time.Now().Format(LOG_ROTATION_TIME_FORMAT)
+	if err := os.Rename(l.filename, backup); err != nil {
+		return err
+	}
+
+	if err := l.open(); err != nil {
+		return err
+	}
+	l.segmentStart = time.Now()
+
+	if l.rotation.Compress {
+		if err := compressFile(backup); err != nil {
+			return err
+		}
+	}
+
+	return l.prune()
+}
+
+// backups returns the rotated files, oldest first.
+func (l *logFile) backups() []string {
+	matches, _ := filepath.Glob(l.filename + ".*")
+
+	backups := make([]string, 0, len(matches))
+	for _, match := range matches {
+		if _, ok := rotationTime(l.filename, match); ok {
+			backups = append(backups, match)
+		}
+	}
+
+	sort.Strings(backups)
+	return backups
+}
+
+func (l *logFile) prune() error {
+	if l.rotation.MaxBackups < 0 {
+		return nil
+	}
+
+	backups := l.backups()
+	for len(backups) > l.rotation.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// rotationTime parses the time a backup of filename was rotated at.
+func rotationTime(filename, backup string) (time.Time, bool) {
+	suffix := strings.TrimSuffix(strings.TrimPrefix(backup, filename+"."), ".gz")
+	rotated, err := time.ParseInLocation(LOG_ROTATION_TIME_FORMAT, suffix, time.Local)
+	return rotated, err == nil
+}
+
+func compressFile(filename string) error {
+	src, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(filename+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(filename)
+}
+
+// readLogHistory returns the lines of the rotated backups of filename and of
+// filename itself, oldest first, and the offset in filename they end at. A
+// line still being written is left for followFile.
+func readLogHistory(filename string) ([]string, int64, error) {
+	var lines []string
+
+	l := &logFile{filename: filename}
+	for _, backup := range l.backups() {
+		content, err := readBackup(backup)
+		if err != nil {
+			return nil, 0, err
+		}
+		lines = append(lines, splitLines(content)...)
+	}
+
+	content, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return lines, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	complete := bytes.LastIndexByte(content, '\n') + 1
+	lines = append(lines, splitLines(content[:complete])...)
+	return lines, int64(complete), nil
+}
+
+func readBackup(filename string) ([]byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if !strings.HasSuffix(filename, ".gz") {
+		return io.ReadAll(file)
+	}
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return io.ReadAll(gz)
+}
+
+// splitLines splits content into lines, keeping their line endings.
+func splitLines(content []byte) []string {
+	var lines []string
+	for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+		if len(line) > 0 {
+			lines = append(lines, string(line))
+		}
+	}
+	return lines
+}
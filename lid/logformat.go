@@ -0,0 +1,228 @@
+package lid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type LogFormat int8
+
+const (
+	// Plain lines prefixed with the name of the service (default)
+	LogFormatText LogFormat = iota
+	// One JSON record per line, see LogRecord
+	LogFormatJSON
+)
+
+func (f LogFormat) String() string {
+	switch f {
+	case LogFormatText:
+		return "text"
+	case LogFormatJSON:
+		return "json"
+	default:
+		return "unknown"
+	}
+}
+
+// LOG_TIME_FORMAT is how LogFormatText timestamps every line, the way
+// log.Logger does.
+const LOG_TIME_FORMAT = "2006/01/02 15:04:05"
+
+// Streams a LogRecord can come from
+const (
+	STREAM_STDOUT = "stdout"
+	STREAM_STDERR = "stderr"
+	STREAM_LID    = "lid" // messages of lid itself about the service
+)
+
+// LogRecord is a line of a log written with LogFormatJSON.
+type LogRecord struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"msg"`
+	Service string    `json:"service,omitempty"`
+	Stream  string    `json:"stream"`
+	Pid     int       `json:"pid,omitempty"`
+}
+
+// ParseLogRecord parses a line written with LogFormatJSON.
+func ParseLogRecord(line string) (LogRecord, bool) {
+	var record LogRecord
+	if err := json.Unmarshal([]byte(line), &record); err != nil || record.Stream == "" {
+		return LogRecord{}, false
+	}
+	return record, true
+}
+
+// String renders the record the way LogFormatText would have written it.
+func (r LogRecord) String() string {
+	line := fmt.Sprintf("%s %s\n", r.Time.Local().Format(LOG_TIME_FORMAT), r.Message)
+	if r.Service != "" {
+		line = fmt.Sprintf("[%s] %s", r.Service, line)
+	}
+	return line
+}
+
+func newRecordHandler(w io.Writer, service, stream string) slog.Handler {
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			// every record is informational, the stream tells them apart
+			if len(groups) == 0 && a.Key == slog.LevelKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+
+	attrs := []slog.Attr{slog.String("stream", stream)}
+	if service != "" {
+		attrs = []slog.Attr{slog.String("service", service), slog.String("stream", stream)}
+	}
+	return handler.WithAttrs(attrs)
+}
+
+// newRecordLogger returns a log.Logger writing every message to w as a
+// LogRecord of the "lid" stream, tagged with the PID of this process.
+func newRecordLogger(w io.Writer, service string) *log.Logger {
+	handler := newRecordHandler(w, service, STREAM_LID).WithAttrs([]slog.Attr{slog.Int("pid", os.Getpid())})
+	return slog.NewLogLogger(handler, slog.LevelInfo)
+}
+
+// recordWriter writes every line of a service's output to a slog.Handler.
+// Launching a process asks it for a writer tagging the lines with the PID.
+type recordWriter struct {
+	handler slog.Handler
+	pid     int32
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+func newRecordWriter(w io.Writer, service, stream string) *recordWriter {
+	return &recordWriter{handler: newRecordHandler(w, service, stream)}
+}
+
+func (w *recordWriter) forProcess(pid int32) io.Writer {
+	return &recordWriter{handler: w.handler, pid: pid}
+}
+
+func (w *recordWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+
+		record := slog.NewRecord(time.Now(), slog.LevelInfo, string(w.partial[:i]), 0)
+		if w.pid != NO_PID {
+			record.AddAttrs(slog.Int("pid", int(w.pid)))
+		}
+		w.partial = w.partial[i+1:]
+
+		if err := w.handler.Handle(context.Background(), record); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// timestampWriter starts every line written to it with the time.
+type timestampWriter struct {
+	w io.Writer
+
+	mu      sync.Mutex
+	midLine bool
+}
+
+func (t *timestampWriter) forProcess(pid int32) io.Writer {
+	return &timestampWriter{w: t.w}
+}
+
+func (t *timestampWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var out bytes.Buffer
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+
+		if !t.midLine {
+			out.WriteString(time.Now().Format(LOG_TIME_FORMAT) + " ")
+		}
+		out.Write(line)
+		t.midLine = line[len(line)-1] != '\n'
+	}
+
+	if _, err := t.w.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// parseLogTime parses the time a line written with LogFormatText starts
+// with, after the name of the service if there is one.
+func parseLogTime(line string) (time.Time, bool) {
+	if strings.HasPrefix(line, "[") {
+		if i := strings.Index(line, "] "); i > 0 {
+			line = line[i+2:]
+		}
+	}
+
+	if len(line) < len(LOG_TIME_FORMAT) {
+		return time.Time{}, false
+	}
+
+	t, err := time.ParseInLocation(LOG_TIME_FORMAT, line[:len(LOG_TIME_FORMAT)], time.Local)
+	return t, err == nil
+}
+
+// processWriter is implemented by writers that keep apart the output of the
+// processes of a service, e.g. to tag it with their PID.
+type processWriter interface {
+	forProcess(pid int32) io.Writer
+}
+
+func writerForProcess(w io.Writer, pid int32) io.Writer {
+	if pw, ok := w.(processWriter); ok {
+		return pw.forProcess(pid)
+	}
+	return w
+}
+
+// renderedWriter renders the LogRecords written to it as text, e.g. to show
+// them in the terminal. Lines that are not records are written as they are.
+type renderedWriter struct {
+	w io.Writer
+}
+
+func (r renderedWriter) Write(p []byte) (int, error) {
+	var out bytes.Buffer
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		if record, ok := ParseLogRecord(string(line)); ok {
+			out.WriteString(record.String())
+		} else {
+			out.Write(line)
+		}
+	}
+
+	if _, err := r.w.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
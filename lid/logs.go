@@ -0,0 +1,353 @@
+package lid
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogsOptions selects what `lid logs` shows.
+type LogsOptions struct {
+	Services   []string // Defaults to all services
+	StderrOnly bool     // Only show what the services wrote to stderr
+
+	// Show the last Lines lines written before following the logs. All of
+	// them are shown if Since, Until or NoFollow are set.
+	Lines int
+	Since time.Time // Only show lines written since then
+	Until time.Time // Only show lines written until then, implies NoFollow
+	// Only show lines matching Grep
+	Grep *regexp.Regexp
+	// Return once the lines written so far were shown
+	NoFollow bool
+}
+
+// timeValue is a flag holding a time, given either as a timestamp or as a
+// duration before now.
+type timeValue struct {
+	t *time.Time
+}
+
+func (v timeValue) String() string {
+	if v.t == nil || v.t.IsZero() {
+		return ""
+	}
+	return v.t.Format(time.RFC3339)
+}
+
+func (v timeValue) Set(value string) error {
+	t, err := parseTime(value, time.Now())
+	if err != nil {
+		return err
+	}
+	*v.t = t
+	return nil
+}
+
+// parseTime parses a time like "10m" (ago), "2024-10-17", "2024-10-17 08:30"
+// or RFC 3339.
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q, expected e.g. 10m, 2006-01-02 or 2006-01-02 15:04:05", value)
+}
+
+// parseLogsArgs parses the arguments of `lid logs`.
+func parseLogsArgs(args []string) (LogsOptions, error) {
+	var options LogsOptions
+	var grep string
+
+	flags := flag.NewFlagSet("logs", flag.ContinueOnError)
+	flags.BoolVar(&options.StderrOnly, "stderr-only", false, "only show what the services wrote to stderr")
+	flags.IntVar(&options.Lines, "n", 0, "show the last `lines` written before following")
+	flags.IntVar(&options.Lines, "lines", 0, "show the last `lines` written before following")
+	flags.Var(timeValue{&options.Since}, "since", "only show lines written since `time` (e.g. 10m or 2006-01-02 15:04)")
+	flags.Var(timeValue{&options.Until}, "until", "only show lines written until `time`, without following")
+	flags.StringVar(&grep, "grep", "", "only show lines matching the `regexp`")
+	flags.BoolVar(&options.NoFollow, "no-follow", false, "exit once the lines written so far were shown")
+
+	services, err := parseArgs(flags, args)
+	if err != nil {
+		return options, err
+	}
+	options.Services = services
+
+	if grep != "" {
+		options.Grep, err = regexp.Compile(grep)
+		if err != nil {
+			err = fmt.Errorf("invalid value %q for flag -grep: %w", grep, err)
+			fmt.Fprintln(flags.Output(), err)
+			return options, err
+		}
+	}
+
+	return options, nil
+}
+
+// runLogs runs `lid logs` with the given arguments.
+func (lid *Lid) runLogs(args []string) {
+	options, err := parseLogsArgs(args)
+	if err != nil {
+		// the flag set already printed the error
+		os.Exit(EXIT_USAGE)
+	}
+
+	for _, name := range options.Services {
+		if _, ok := lid.services[name]; !ok {
+			fmt.Fprintf(os.Stderr, "Service '%s' not found\n", name)
+			os.Exit(EXIT_USAGE)
+		}
+	}
+
+	if options.StderrOnly && lid.logFormat == LogFormatText {
+		for _, name := range lid.selectServices(options.Services) {
+			if service := lid.services[name]; service.LogFile != "" && service.ErrLogFile == service.LogFile {
+				fmt.Fprintf(os.Stderr, "--stderr-only cannot tell the stderr of '%s' apart, its ErrLogFile is its LogFile\n", name)
+				os.Exit(EXIT_USAGE)
+			}
+		}
+	}
+
+	lid.LogsWithOptions(options)
+}
+
+// Logs follows lid's own log, filtered to the given services (or all of
+// them), together with the output of these services.
+func (lid *Lid) Logs(services []string) {
+	lid.LogsWithOptions(LogsOptions{Services: services})
+}
+
+// logLine is a line of a log, rendered as text.
+type logLine struct {
+	time   time.Time // zero if the line does not tell
+	text   string
+	stream string // empty if the log does not tell
+}
+
+// logSource is a log file and how to read its lines, reporting false for
+// lines that were not asked for.
+type logSource struct {
+	filename string
+	parse    func(line string) (logLine, bool)
+}
+
+// LogsWithOptions shows the logs selected by options, merging the lines
+// written so far by time, and then follows them. Lines the services wrote to
+// stderr are highlighted.
+func (lid *Lid) LogsWithOptions(options LogsOptions) {
+	sources := lid.logSources(options)
+
+	matches := func(line logLine) bool {
+		if options.StderrOnly && line.stream != STREAM_STDERR {
+			return false
+		}
+
+		if !line.time.IsZero() {
+			if !options.Since.IsZero() && line.time.Before(options.Since) {
+				return false
+			}
+
+			if !options.Until.IsZero() && line.time.After(options.Until) {
+				return false
+			}
+		}
+
+		return options.Grep == nil || options.Grep.MatchString(strings.TrimSuffix(line.text, "\n"))
+	}
+
+	var mu sync.Mutex
+	printLine := func(line logLine) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if line.stream == STREAM_STDERR {
+			fmt.Printf("\033[31m%s\033[0m\n", strings.TrimSuffix(line.text, "\n"))
+		} else {
+			fmt.Print(line.text)
+		}
+	}
+
+	follow := !options.NoFollow && options.Until.IsZero()
+	history := options.Lines > 0 || !options.Since.IsZero() || !follow
+
+	offsets := make([]int64, len(sources))
+	for i := range offsets {
+		offsets[i] = -1
+	}
+
+	if history {
+		var lines []logLine
+		for i, source := range sources {
+			contents, offset, err := readLogHistory(source.filename)
+			if err != nil {
+				lid.logger.Printf("Failed to read %s: %v\n", source.filename, err)
+				continue
+			}
+			offsets[i] = offset
+
+			// a line without a time, e.g. of a stack trace, was written
+			// right after the one before it
+			var last time.Time
+			for _, content := range contents {
+				line, ok := source.parse(content)
+				if !ok {
+					continue
+				}
+
+				if line.time.IsZero() {
+					line.time = last
+				}
+				last = line.time
+
+				if matches(line) {
+					lines = append(lines, line)
+				}
+			}
+		}
+
+		sort.SliceStable(lines, func(i, j int) bool {
+			return lines[i].time.Before(lines[j].time)
+		})
+
+		if options.Lines > 0 && len(lines) > options.Lines {
+			lines = lines[len(lines)-options.Lines:]
+		}
+
+		for _, line := range lines {
+			printLine(line)
+		}
+	}
+
+	if !follow {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := followFile(context.Background(), source.filename, offsets[i], func(content string) bool {
+				line, ok := source.parse(content)
+				if !ok {
+					return true
+				}
+
+				if line.time.IsZero() {
+					line.time = time.Now()
+				}
+
+				if matches(line) {
+					printLine(line)
+				}
+				return true
+			})
+
+			if err != nil {
+				lid.logger.Printf("Failed to follow %s: %v\n", source.filename, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// logSources returns lid's own log and the log files of the services
+// selected by options.
+func (lid *Lid) logSources(options LogsOptions) []logSource {
+	names := lid.selectServices(options.Services)
+	var sources []logSource
+
+	sources = append(sources, logSource{
+		filename: lid.logsFilename,
+		parse: func(content string) (logLine, bool) {
+			if record, ok := lid.parseLogLine(content); ok {
+				if len(options.Services) > 0 && !contains(names, record.Service) {
+					return logLine{}, false
+				}
+				return logLine{time: record.Time, text: record.String(), stream: STREAM_LID}, true
+			}
+
+			if len(options.Services) > 0 && !hasServicePrefix(content, names) {
+				return logLine{}, false
+			}
+
+			t, _ := parseLogTime(content)
+			return logLine{time: t, text: content, stream: STREAM_LID}, true
+		},
+	})
+
+	// output follows a log file of a service, with the stream of the lines
+	// that are not records
+	output := func(name, filename, stream string) logSource {
+		// make sure there is something to follow even before the first start
+		if file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+			file.Close()
+		}
+
+		prefix := fmt.Sprintf("[%s] ", name)
+		return logSource{
+			filename: filename,
+			parse: func(content string) (logLine, bool) {
+				if record, ok := lid.parseLogLine(content); ok {
+					return logLine{time: record.Time, text: record.String(), stream: record.Stream}, true
+				}
+
+				t, _ := parseLogTime(content)
+				return logLine{time: t, text: prefix + content, stream: stream}, true
+			},
+		}
+	}
+
+	for _, name := range names {
+		service := lid.services[name]
+		if service.LogFile == "" {
+			continue
+		}
+
+		if service.ErrLogFile == "" || service.ErrLogFile == service.LogFile {
+			// only records tell the streams apart
+			sources = append(sources, output(name, service.LogFile, ""))
+			continue
+		}
+
+		sources = append(sources,
+			output(name, service.LogFile, STREAM_STDOUT),
+			output(name, service.ErrLogFile, STREAM_STDERR),
+		)
+	}
+
+	return sources
+}
+
+func hasServicePrefix(line string, names []string) bool {
+	for _, name := range names {
+		if strings.HasPrefix(line, fmt.Sprintf("[%s] ", name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLogLine parses a line of a log written in the LogFormat of lid.
+func (lid *Lid) parseLogLine(line string) (LogRecord, bool) {
+	if lid.logFormat != LogFormatJSON {
+		return LogRecord{}, false
+	}
+	return ParseLogRecord(line)
+}
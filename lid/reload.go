@@ -0,0 +1,163 @@
+package lid
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// RELOAD_SIGNAL asks the process supervising a service to reload it.
+const RELOAD_SIGNAL = syscall.SIGUSR2
+
+// Reload replaces the running process with a new one without downtime.
+//
+// The new process is started next to the old one and only once it passed its
+// readiness check the old process is sent ExitSignal. If the new process never
+// becomes ready it is stopped and the old one keeps running. ExitCommand is not
+// used, since it would take down both processes.
+//
+// Reload must be called from the process running Start. Every failed reload
+// is counted in the state together with its error, for requestReload.
+func (s *Service) Reload() error {
+	err := s.reload()
+	if err != nil {
+		s.updateServiceProcess(func(sp *ServiceProcess) {
+			sp.ReloadFailures++
+			sp.ReloadError = err.Error()
+		})
+	}
+	return err
+}
+
+func (s *Service) reload() error {
+	s.instanceMu.Lock()
+	old := s.current
+	if old == nil || old.exited() {
+		s.instanceMu.Unlock()
+		return ErrProcessNotFound
+	}
+	if s.reloading != nil {
+		s.instanceMu.Unlock()
+		return ErrReloadInProgress
+	}
+	s.reloading = make(chan struct{})
+	s.instanceMu.Unlock()
+
+	defer func() {
+		s.instanceMu.Lock()
+		close(s.reloading)
+		s.reloading = nil
+		s.instanceMu.Unlock()
+	}()
+
+	s.Logger.Printf("Reloading, replacing PID %d\n", old.pid)
+
+	cmd, err := s.prepareCommand(s.Command)
+	if err != nil {
+		s.Logger.Printf("Reload failed: %v\n", err)
+		return err
+	}
+	s.passListeners(cmd)
+
+	next, err := s.launch(cmd)
+	if err != nil {
+		s.Logger.Printf("Reload failed: %v\n", err)
+		return err
+	}
+
+	s.updateServiceProcess(func(sp *ServiceProcess) {
+		sp.NextPid = next.pid
+		sp.NextStartTime = next.startTime
+	})
+
+	ready, err := s.waitReady(next)
+	if !ready {
+		if err == nil {
+			err = fmt.Errorf("new process exited before becoming ready")
+		}
+
+		s.Logger.Printf("Reload failed: %v. Keeping PID %d\n", err, old.pid)
+		s.stopInstance(next)
+		s.updateServiceProcess(func(sp *ServiceProcess) {
+			sp.NextPid = NO_PID
+			sp.NextStartTime = 0
+		})
+		return fmt.Errorf("reload failed: %w", err)
+	}
+
+	// the new process got the env files as they are now
+	configHash, err := s.ConfigHash()
+	if err != nil {
+		s.Logger.Printf("Failed to hash the config: %v\n", err)
+	}
+
+	s.setCurrent(next)
+	s.updateServiceProcess(func(sp *ServiceProcess) {
+		sp.Status = RUNNING
+		sp.Pid = next.pid
+		sp.StartTime = next.startTime
+		sp.ConfigHash = configHash
+		sp.NextPid = NO_PID
+		sp.NextStartTime = 0
+		sp.Health = HEALTH_UNKNOWN
+		sp.HealthFailures = 0
+		if s.healthCheckGatesReadiness() {
+			sp.Health = HEALTHY
+		}
+	})
+
+	if s.HealthCheck != nil {
+		go s.monitorHealth(next)
+	}
+
+	if s.OnAfterStart != nil {
+		s.OnAfterStart(s)
+	}
+
+	s.Logger.Printf("Handed over to PID %d, stopping PID %d\n", next.pid, old.pid)
+	s.stopInstance(old)
+	return nil
+}
+
+// requestReload asks the process supervising the service to reload it and
+// waits for the handoff to complete.
+func (s *Service) requestReload() error {
+	state := s.getCachedProcessState()
+	if state.SupervisorPid == NO_PID {
+		return fmt.Errorf("no supervisor process recorded for '%s'", s.Name)
+	}
+
+	supervisor, err := findProcess(state.SupervisorPid, state.SupervisorStartTime)
+	if err != nil {
+		return fmt.Errorf("supervisor process %d not found: %w", state.SupervisorPid, err)
+	}
+
+	if err := supervisor.SendSignal(RELOAD_SIGNAL); err != nil {
+		return fmt.Errorf("failed to signal supervisor process %d: %w", state.SupervisorPid, err)
+	}
+
+	oldPid := state.Pid
+	failures := state.ReloadFailures
+	deadline := time.Now().Add(s.ReadinessCheckTimeout + s.GracefulShutdownTimeout + time.Second)
+
+	for time.Now().Before(deadline) {
+		state = s.getCachedProcessState()
+
+		if state.Pid != oldPid && state.Pid != NO_PID {
+			s.Logger.Printf("Reloaded, PID %d replaced PID %d\n", state.Pid, oldPid)
+			return nil
+		}
+
+		if state.ReloadFailures != failures {
+			return fmt.Errorf("%s, PID %d kept running", state.ReloadError, oldPid)
+		}
+
+		if state.Status == STOPPED || state.Status == STOPPING {
+			return fmt.Errorf("service was stopped during the reload")
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return fmt.Errorf("timed out waiting for the reload to complete")
+}
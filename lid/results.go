@@ -0,0 +1,336 @@
+package lid
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/rpc"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Exit codes of the lid CLI. When several services end up differently, the
+// first of EXIT_FAILURE, EXIT_LOCKED and EXIT_NOT_RUNNING that applies wins.
+const (
+	EXIT_OK      = 0
+	EXIT_FAILURE = 1 // a service failed to start, stop or reload, or `lid check` found a problem
+	EXIT_USAGE   = 2 // unknown command, flag or service
+	// `stop` found a service it was asked for by name not running, `list`
+	// shows a service that exited, is backing off or is FATAL, or `status`
+	// shows a service that is not running
+	EXIT_NOT_RUNNING = 3
+	EXIT_LOCKED      = 4 // another lid process kept a service busy for longer than the LockTimeout
+)
+
+// Outcome is what a command did to a service.
+type Outcome string
+
+const (
+	OUTCOME_STARTED         Outcome = "started"
+	OUTCOME_ALREADY_RUNNING Outcome = "already-running"
+	OUTCOME_STOPPED         Outcome = "stopped"
+	OUTCOME_NOT_RUNNING     Outcome = "not-running" // stop found nothing to stop
+	OUTCOME_BACKING_OFF     Outcome = "backing-off" // start found the supervisor waiting to restart the service
+	OUTCOME_RELOADED        Outcome = "reloaded"
+	OUTCOME_SKIPPED         Outcome = "skipped" // a dependency is not running, or the service is FATAL
+	OUTCOME_LOCKED          Outcome = "locked"
+	OUTCOME_FAILED          Outcome = "failed"
+)
+
+// ServiceResult is what `lid start`, `lid stop`, `lid restart` or
+// `lid reload` did to a service, printed as JSON with --json.
+type ServiceResult struct {
+	Name     string        `json:"name"`
+	Action   string        `json:"action"`
+	Outcome  Outcome       `json:"outcome"`
+	Pid      int32         `json:"pid,omitempty"`
+	Duration time.Duration `json:"duration"` // In seconds in JSON
+	Error    string        `json:"error,omitempty"`
+	// Whether the service was asked for by name rather than as a dependency
+	// or as one of all services
+	Explicit bool `json:"-"`
+}
+
+type serviceResultJSON ServiceResult
+
+func (r ServiceResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		serviceResultJSON
+		Duration float64 `json:"duration"`
+	}{serviceResultJSON(r), r.Duration.Seconds()})
+}
+
+func (r *ServiceResult) UnmarshalJSON(data []byte) error {
+	var result struct {
+		serviceResultJSON
+		Duration float64 `json:"duration"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return err
+	}
+
+	*r = ServiceResult(result.serviceResultJSON)
+	r.Duration = time.Duration(result.Duration * float64(time.Second))
+	return nil
+}
+
+// ExitCode returns the exit code of the CLI for the result.
+func (r ServiceResult) ExitCode() int {
+	switch r.Outcome {
+	case OUTCOME_FAILED, OUTCOME_SKIPPED:
+		return EXIT_FAILURE
+	case OUTCOME_LOCKED:
+		return EXIT_LOCKED
+	case OUTCOME_NOT_RUNNING:
+		if r.Explicit {
+			return EXIT_NOT_RUNNING
+		}
+	}
+	return EXIT_OK
+}
+
+// ExitCode returns the exit code of the CLI for a command with results.
+func ExitCode(results []ServiceResult) int {
+	code := EXIT_OK
+	for _, result := range results {
+		switch c := result.ExitCode(); {
+		case c == EXIT_FAILURE:
+			return c
+		case c == EXIT_LOCKED, c == EXIT_NOT_RUNNING && code == EXIT_OK:
+			code = c
+		}
+	}
+	return code
+}
+
+// resultsError returns an error naming the services a command failed for,
+// like ErrStartFailed for `lid start`.
+func resultsError(base error, results []ServiceResult) error {
+	var failed []string
+	for _, result := range results {
+		if code := result.ExitCode(); code == EXIT_FAILURE || code == EXIT_LOCKED {
+			failed = append(failed, result.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%w: %s", base, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// restartResults combines the results of stopping and then starting services
+// into the results of restarting them.
+func restartResults(stopped, started []ServiceResult) []ServiceResult {
+	stops := make(map[string]ServiceResult, len(stopped))
+	for _, result := range stopped {
+		stops[result.Name] = result
+	}
+
+	results := make([]ServiceResult, 0, len(started))
+	for _, result := range started {
+		stop, ok := stops[result.Name]
+		if ok && (stop.Outcome == OUTCOME_FAILED || stop.Outcome == OUTCOME_LOCKED) {
+			result = stop
+		}
+		result.Action = "restart"
+		result.Duration += stop.Duration
+		results = append(results, result)
+	}
+	return results
+}
+
+// listExitCode returns the exit code of `lid list` showing infos.
+func listExitCode(infos []ServiceInfo) int {
+	for _, info := range infos {
+		if info.Status == EXITED || info.Status == BACKOFF || info.Status == FATAL {
+			return EXIT_NOT_RUNNING
+		}
+	}
+	return EXIT_OK
+}
+
+// printEach prints every item as a JSON object on its own line, or with the
+// --format template if one was given.
+func printEach[T any](items []T, options commandOptions) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	for _, item := range items {
+		if options.Format == nil {
+			if err := encoder.Encode(item); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := options.Format.Execute(os.Stdout, item); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// commandOptions are the arguments of the commands printing a result or a
+// ServiceInfo per service.
+type commandOptions struct {
+	Services []string
+	JSON     bool
+	Format   *template.Template // Go template printing every service
+}
+
+// printsItems reports whether the command prints every service as JSON or
+// with a template instead of for humans.
+func (o commandOptions) printsItems() bool {
+	return o.JSON || o.Format != nil
+}
+
+// formatFuncs are the functions --format templates can use besides the
+// builtin ones.
+var formatFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"join": strings.Join,
+}
+
+// parseCommandArgs parses the arguments of command and checks that the
+// services exist.
+func (lid *Lid) parseCommandArgs(command string, args []string) (commandOptions, error) {
+	var options commandOptions
+	var format string
+
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.BoolVar(&options.JSON, "json", false, "print every service as a JSON object")
+	flags.StringVar(&format, "format", "", "print every service with the Go `template`, e.g. '{{.Name}} {{.Status}}'")
+
+	services, err := parseArgs(flags, args)
+	if err != nil {
+		return options, err
+	}
+
+	if format != "" {
+		options.Format, err = template.New(command).Funcs(formatFuncs).Parse(format)
+		if err != nil {
+			err = fmt.Errorf("invalid value %q for flag -format: %w", format, err)
+			fmt.Fprintln(flags.Output(), err)
+			return options, err
+		}
+	}
+
+	if command == "status" && len(services) != 1 {
+		err := fmt.Errorf("status takes exactly one service")
+		fmt.Fprintln(flags.Output(), err)
+		return options, err
+	}
+
+	for _, name := range services {
+		if _, ok := lid.services[name]; !ok {
+			err := fmt.Errorf("Service '%s' not found", name)
+			fmt.Fprintln(flags.Output(), err)
+			return options, err
+		}
+	}
+
+	options.Services = services
+	return options, nil
+}
+
+// runCommand runs a command printing a result or a ServiceInfo per service,
+// through the daemon if client is set, and exits with the exit code of the
+// results.
+func (lid *Lid) runCommand(client *rpc.Client, command string, args []string) {
+	options, err := lid.parseCommandArgs(command, args)
+	if err != nil {
+		// the flag set already printed the error
+		os.Exit(EXIT_USAGE)
+	}
+	lid.printingResults.Store(options.printsItems())
+
+	switch command {
+	case "list", "ls", "status":
+		var infos []ServiceInfo
+		if client != nil {
+			infos, err = lid.daemonStatuses(client, options.Services)
+		}
+		if client == nil || !lid.daemonCallDone(err) {
+			infos = lid.Statuses(options.Services)
+		}
+
+		switch {
+		case options.printsItems():
+			err = printEach(infos, options)
+		case command == "status":
+			renderStatus(infos[0])
+		default:
+			renderStatuses(infos)
+		}
+		exitOnPrintError(err)
+
+		if command == "status" && !infos[0].IsUp() {
+			os.Exit(EXIT_NOT_RUNNING)
+		}
+		os.Exit(listExitCode(infos))
+	}
+
+	var results []ServiceResult
+	var reply DaemonReply
+	if client != nil {
+		reply, err = lid.callDaemon(client, command, options.Services)
+	}
+
+	if client != nil && lid.daemonCallDone(err) {
+		results = reply.Results
+		if !options.printsItems() {
+			printDaemonReply(reply)
+		}
+	} else {
+		results = lid.runLocally(command, options.Services)
+	}
+
+	if options.printsItems() {
+		exitOnPrintError(printEach(results, options))
+	} else if err := resultsError(commandError(command), results); err != nil {
+		log.Println(err)
+	}
+	os.Exit(ExitCode(results))
+}
+
+// exitOnPrintError exits if a --format template could not be applied.
+func exitOnPrintError(err error) {
+	if err != nil {
+		log.Println(err)
+		os.Exit(EXIT_USAGE)
+	}
+}
+
+// runLocally runs a command printing results in this process.
+func (lid *Lid) runLocally(command string, services []string) []ServiceResult {
+	switch command {
+	case "start":
+		return lid.StartServices(services)
+	case "stop":
+		return lid.StopServices(services)
+	case "restart":
+		return lid.Restart(services)
+	default:
+		return lid.ReloadServices(services)
+	}
+}
+
+// commandError is the error a command reports the services it failed for
+// with.
+func commandError(command string) error {
+	switch command {
+	case "stop":
+		return ErrStopFailed
+	case "reload":
+		return ErrReloadFailed
+	default:
+		return ErrStartFailed
+	}
+}
@@ -2,14 +2,17 @@ package lid
 
 import (
 	"bufio"
-	"bytes"
-	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/rand/v2"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -33,6 +36,8 @@ const (
 	STARTING
 	RUNNING
 	STOPPING
+	BACKOFF // Waiting to be restarted
+	FATAL   // Crashed too often, no longer restarted
 )
 
 func (s ServiceStatus) String() string {
@@ -47,38 +52,154 @@ func (s ServiceStatus) String() string {
 		return "Running"
 	case STOPPING:
 		return "Stopping"
+	case BACKOFF:
+		return "Backoff"
+	case FATAL:
+		return "Fatal"
 	default:
 		return "Unknown"
 	}
 }
 
+func (s ServiceStatus) MarshalText() ([]byte, error) {
+	if s < STOPPED || s > FATAL {
+		return nil, fmt.Errorf("unknown service status %d", s)
+	}
+	return []byte(strings.ToLower(s.String())), nil
+}
+
+func (s *ServiceStatus) UnmarshalText(text []byte) error {
+	for status := STOPPED; status <= FATAL; status++ {
+		if strings.EqualFold(string(text), status.String()) {
+			*s = status
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown service status %q", text)
+}
+
+type RestartPolicy int8
+
+const (
+	// Never restart the service once its process exits (default)
+	RestartNever RestartPolicy = iota
+	// Restart only when the process exits with a non-zero exit code
+	RestartOnFailure
+	// Restart whenever the process exits, up to MaxRestarts
+	RestartAlways
+	// Restart whenever the process exits until it is stopped with `lid stop`.
+	// MaxRestarts is ignored.
+	RestartUnlessStopped
+)
+
+func (p RestartPolicy) String() string {
+	switch p {
+	case RestartNever:
+		return "never"
+	case RestartOnFailure:
+		return "on-failure"
+	case RestartAlways:
+		return "always"
+	case RestartUnlessStopped:
+		return "unless-stopped"
+	default:
+		return "unknown"
+	}
+}
+
+func (p *RestartPolicy) UnmarshalText(text []byte) error {
+	for policy := RestartNever; policy <= RestartUnlessStopped; policy++ {
+		if strings.EqualFold(string(text), policy.String()) {
+			*p = policy
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown restart policy %q", text)
+}
+
+// ServiceProcess is the state of a service shared between lid processes.
+//
+// Every PID is stored together with the start time of its process, in
+// milliseconds since the epoch as reported by the OS. A PID whose process
+// started at another time was reused by an unrelated program after the
+// service's process died, e.g. across a reboot. The command line is not
+// compared: services with Listeners exec through a shell, and processes may
+// rewrite their arguments.
 type ServiceProcess struct {
-	Status ServiceStatus
-	Pid    int32
+	Status    ServiceStatus `json:"status"`
+	Pid       int32         `json:"pid"`
+	StartTime int64         `json:"start_time,omitempty"`
+	Restarts  int32         `json:"restarts"`  // Restarts since the service was last started explicitly
+	ExitCode  int32         `json:"exit_code"` // Exit code of the last process that exited
+
+	NextPid             int32  `json:"next_pid,omitempty"` // Process taking over from Pid while a reload is in flight
+	NextStartTime       int64  `json:"next_start_time,omitempty"`
+	SupervisorPid       int32  `json:"supervisor_pid,omitempty"` // The lid process supervising the service
+	SupervisorStartTime int64  `json:"supervisor_start_time,omitempty"`
+	ReloadFailures      int32  `json:"reload_failures,omitempty"` // Reloads that failed, e.g. because the new process never became ready
+	ReloadError         string `json:"reload_error,omitempty"`    // Why the last failed reload failed
+
+	Health          HealthStatus `json:"health"`
+	HealthFailures  int32        `json:"health_failures,omitempty"`  // Consecutive failed health checks
+	LivenessActions int32        `json:"liveness_actions,omitempty"` // Liveness failure actions taken since the service was last started explicitly
+
+	ConfigHash string `json:"config_hash,omitempty"` // Service.ConfigHash of the supervisor that started Pid
 }
 
+// WriteToFile atomically replaces filename with the state, so readers never
+// see a partially written file.
 func (sp ServiceProcess) WriteToFile(filename string) error {
-	buf := new(bytes.Buffer)
-	err := binary.Write(buf, binary.LittleEndian, sp)
+	data, err := json.Marshal(stateFile{Version: STATE_VERSION, ServiceProcess: sp})
+	if err != nil {
+		return err
+	}
 
+	tempFile, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tempFile.Name())
 
-	return os.WriteFile(filename, buf.Bytes(), 0666)
+	if _, err := tempFile.Write(append(data, '\n')); err != nil {
+		tempFile.Close()
+		return err
+	}
+
+	if err := tempFile.Chmod(0666); err != nil {
+		tempFile.Close()
+		return err
+	}
+
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tempFile.Name(), filename)
 }
 
+// ReadServiceProcess reads a state file in the current format or in the
+// binary format of lid versions before it was versioned. A file in neither
+// format yields ErrProcessCorrupt.
 func ReadServiceProcess(filename string) (ServiceProcess, error) {
-	var data ServiceProcess
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return ServiceProcess{}, err
+	}
 
-	file, err := os.Open(filename)
+	if len(data) == LEGACY_STATE_SIZE {
+		return readLegacyServiceProcess(data)
+	}
 
-	if err != nil {
-		return data, err
+	var state stateFile
+	if err := json.Unmarshal(data, &state); err != nil {
+		return ServiceProcess{}, fmt.Errorf("%w: %s: %v", ErrProcessCorrupt, filename, err)
 	}
 
-	err = binary.Read(file, binary.LittleEndian, &data)
-	return data, err
+	if state.Version < 1 || state.Version > STATE_VERSION {
+		return ServiceProcess{}, fmt.Errorf("%w: %s: unsupported version %d", ErrProcessCorrupt, filename, state.Version)
+	}
+
+	return state.ServiceProcess, nil
 }
 
 type Service struct {
@@ -90,8 +211,12 @@ type Service struct {
 
 	Command []string
 
-	EnvFile string
-	Env     []string
+	// Directory the state of the service is kept in
+	StateDir string
+
+	InheritEnv bool
+	EnvFiles   []string
+	Env        []string
 
 	GracefulShutdownTimeout time.Duration
 	ReadinessCheckTimeout   time.Duration
@@ -99,13 +224,57 @@ type Service struct {
 	Stdout io.Writer
 	Stderr io.Writer
 
+	LogFile     string
+	ErrLogFile  string
+	LogRotation *LogRotation
+
 	StdoutReadinessCheck func(line string) bool
 	OnBeforeStart        func(self *Service) error
 	OnAfterStart         func(self *Service)
 	OnExit               func(e *exec.ExitError, self *Service)
+	OnFatal              func(self *Service)
 
 	ExitSignal  syscall.Signal
 	ExitCommand []string
+
+	Listeners []string
+
+	DependsOn []string
+
+	HealthCheck *HealthCheck
+
+	RestartPolicy   RestartPolicy
+	MaxRestarts     int
+	RestartWindow   time.Duration
+	RestartDelay    time.Duration
+	MaxRestartDelay time.Duration
+
+	CrashLoopThreshold int
+	CrashLoopWindow    time.Duration
+
+	// restart times within RestartWindow, used for limits and backoff
+	restarts     []time.Time
+	restartCount int32
+	// exit times within CrashLoopWindow, used for crash loop detection
+	exits []time.Time
+
+	listeners     []net.Listener
+	listenerFiles []*os.File
+
+	instanceMu sync.Mutex
+	current    *instance
+	reloading  chan struct{} // closed when an in-flight reload is done
+	// set when a failing liveness check stopped the process to restart it
+	forceRestart bool
+
+	// held while Start runs, see lockSupervisor
+	supervisorLock  *fileLock
+	supervisorDepth int
+
+	// where the outcome of the next start is reported to, see ForkSpawn
+	spawnReporter *spawnReporter
+	// processes launched by this Service, see LID_INSTANCE_ENV
+	launches int
 }
 
 // ServiceConfig defines how a service should be run and managed.
@@ -121,9 +290,17 @@ type ServiceConfig struct {
 	// The actual command to execute (e.g. ["node", "server.js"])
 	Command []string
 
-	// Environment configuration
-	EnvFile string   // Path to a .env file
-	Env     []string // Additional environment variables (overrides EnvFile)
+	// Where to keep the state of the service. Set by Lid.Register, defaults to
+	// a directory unique to the running executable.
+	StateDir string
+
+	// Environment configuration, layered in this order (see Environment)
+	InheritEnv *bool    // Whether the service inherits the environment of lid (defaults to true)
+	EnvFiles   []string // Paths to .env files, relative to Cwd. Later files override earlier ones, a "?" suffix makes one optional.
+	Env        []string // Additional environment variables as KEY=value (overrides EnvFiles)
+
+	// Deprecated: use EnvFiles. Read before EnvFiles if set.
+	EnvFile string
 
 	// Timing configurations
 	GracefulShutdownTimeout time.Duration // How long to wait for graceful shutdown
@@ -134,11 +311,22 @@ type ServiceConfig struct {
 	Stderr io.Writer // Service's stderr destination
 	Logger *log.Logger
 
+	// File Lid.Register sends the output to, unless Stdout or Stderr are set.
+	// Defaults to <LidOptions.LogsDir>/<service>.log.
+	LogFile string
+	// File Lid.Register sends stderr to, unless Stderr is set. Defaults to
+	// LogFile with ".err" before the extension, or to LogFile itself with
+	// LogFormatJSON, where the records tell the two streams apart.
+	ErrLogFile string
+	// Overrides LidOptions.LogRotation for LogFile
+	LogRotation *LogRotation
+
 	// Lifecycle hooks
 	StdoutReadinessCheck func(line string) bool                 // Check service output to determine if it's ready
 	OnBeforeStart        func(self *Service) error              // Called just before service starts
 	OnAfterStart         func(self *Service)                    // Called right after service starts
 	OnExit               func(e *exec.ExitError, self *Service) // Called when service exits
+	OnFatal              func(self *Service)                    // Called when the service gives up restarting and becomes FATAL
 
 	// What signal to send when stopping the service (defaults to SIGTERM).
 	// If ExitCommand is provided, it will be used instead.
@@ -146,6 +334,35 @@ type ServiceConfig struct {
 
 	// Command to run to exit the service. Defaults to nil. (sends ExitSignal)
 	ExitCommand []string
+
+	// Sockets lid binds once and passes to the service, e.g. "tcp://:8080" or
+	// "unix:///run/app.sock". They are handed to every new process, including
+	// the replacement during a reload, so no connection is ever refused.
+	// See the listen package for picking them up in Go services.
+	Listeners []string
+
+	// Services that have to be running before this one is started. They are
+	// started first by `lid start` and stopped last by `lid stop`.
+	DependsOn []string
+
+	// Probe the service over HTTP, TCP or with a command while it runs, and
+	// optionally before it counts as ready. See HealthCheck.
+	HealthCheck *HealthCheck
+
+	// What to do when the process exits on its own (defaults to RestartNever).
+	// Restarts are delayed with an exponential backoff with jitter, starting at
+	// RestartDelay and doubling on every restart within RestartWindow.
+	RestartPolicy   RestartPolicy
+	MaxRestarts     int           // Give up after this many restarts within RestartWindow (0 = unlimited)
+	RestartWindow   time.Duration // Window restarts are counted in (0 = since the service was started)
+	RestartDelay    time.Duration // Delay before the first restart (defaults to 1s)
+	MaxRestartDelay time.Duration // Upper bound for the backoff delay (defaults to 30s)
+
+	// A service that exits CrashLoopThreshold times within CrashLoopWindow is
+	// considered crash looping: it is no longer restarted and becomes FATAL
+	// until it is started explicitly with `lid start <service>`.
+	CrashLoopThreshold int           // Defaults to 5, negative disables crash loop detection
+	CrashLoopWindow    time.Duration // Defaults to 1 minute
 }
 
 func NewService(name string, config ServiceConfig) *Service {
@@ -173,16 +390,57 @@ func NewService(name string, config ServiceConfig) *Service {
 		config.ExitSignal = syscall.SIGTERM
 	}
 
+	if config.InheritEnv == nil {
+		inherit := true
+		config.InheritEnv = &inherit
+	}
+
+	var envFiles []string
+	if config.EnvFile != "" {
+		envFiles = append(envFiles, config.EnvFile)
+	}
+	envFiles = append(envFiles, config.EnvFiles...)
+
 	if config.Env == nil {
 		config.Env = []string{}
 	}
 
+	if config.RestartDelay == 0 {
+		config.RestartDelay = 1 * time.Second
+	}
+
+	if config.MaxRestartDelay == 0 {
+		config.MaxRestartDelay = 30 * time.Second
+	}
+
+	if config.CrashLoopThreshold == 0 {
+		config.CrashLoopThreshold = 5
+	}
+
+	if config.CrashLoopWindow == 0 {
+		config.CrashLoopWindow = 1 * time.Minute
+	}
+
+	if config.HealthCheck != nil {
+		config.HealthCheck = config.HealthCheck.withDefaults()
+	}
+
+	if config.StateDir == "" {
+		config.StateDir = defaultStateDir("")
+	}
+
+	if err := os.MkdirAll(config.StateDir, 0755); err != nil {
+		config.Logger.Printf("Failed to create state directory: %v\n", err)
+	}
+
 	service := &Service{
 		mu:                      sync.RWMutex{},
 		Name:                    name,
 		Cwd:                     config.Cwd,
 		Command:                 config.Command,
-		EnvFile:                 config.EnvFile,
+		StateDir:                config.StateDir,
+		InheritEnv:              *config.InheritEnv,
+		EnvFiles:                envFiles,
 		Env:                     config.Env,
 		GracefulShutdownTimeout: config.GracefulShutdownTimeout,
 		ReadinessCheckTimeout:   config.ReadinessCheckTimeout,
@@ -190,18 +448,32 @@ func NewService(name string, config ServiceConfig) *Service {
 		OnBeforeStart:           config.OnBeforeStart,
 		OnAfterStart:            config.OnAfterStart,
 		OnExit:                  config.OnExit,
+		OnFatal:                 config.OnFatal,
 		Stdout:                  config.Stdout,
 		Stderr:                  config.Stderr,
+		LogFile:                 config.LogFile,
+		ErrLogFile:              config.ErrLogFile,
+		LogRotation:             config.LogRotation,
 		Logger:                  config.Logger,
 		ExitSignal:              config.ExitSignal,
 		ExitCommand:             config.ExitCommand,
+		Listeners:               config.Listeners,
+		DependsOn:               config.DependsOn,
+		HealthCheck:             config.HealthCheck,
+		RestartPolicy:           config.RestartPolicy,
+		MaxRestarts:             config.MaxRestarts,
+		RestartWindow:           config.RestartWindow,
+		RestartDelay:            config.RestartDelay,
+		MaxRestartDelay:         config.MaxRestartDelay,
+		CrashLoopThreshold:      config.CrashLoopThreshold,
+		CrashLoopWindow:         config.CrashLoopWindow,
 	}
 
 	return service
 }
 
 func (s *Service) GetServiceProcessFilename() string {
-	return filepath.Join(os.TempDir(), fmt.Sprintf("service-%s.lid", s.Name))
+	return filepath.Join(s.StateDir, fmt.Sprintf("service-%s.lid", s.Name))
 }
 
 func (s *Service) getCachedProcessState() ServiceProcess {
@@ -221,24 +493,47 @@ func (s *Service) getCachedProcessState() ServiceProcess {
 func (s *Service) WriteServiceProcess(sp ServiceProcess) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+
+	lock, err := s.lockState()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
 	return sp.WriteToFile(s.GetServiceProcessFilename())
 }
 
-func (s *Service) GetRunningProcess() (*process.Process, error) {
-	state := s.getCachedProcessState()
-	proc, err := process.NewProcess(int32(state.Pid))
+// updateServiceProcess reads the cached state, applies update and writes it
+// back, keeping the fields update does not touch (restart count, exit code).
+// Other lid processes cannot change the state in between.
+func (s *Service) updateServiceProcess(update func(sp *ServiceProcess)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
+	lock, err := s.lockState()
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer lock.Unlock()
 
-	running, err := proc.IsRunning()
-
-	if !running || err != nil {
-		return nil, err
+	sp, err := ReadServiceProcess(s.GetServiceProcessFilename())
+	if err != nil {
+		sp = ServiceProcess{
+			Pid:    NO_PID,
+			Status: STOPPED,
+		}
 	}
 
-	return proc, nil
+	update(&sp)
+	return sp.WriteToFile(s.GetServiceProcessFilename())
+}
+
+// GetRunningProcess returns the process of the service, or an error if it is
+// not running. A PID that now belongs to another process yields
+// ErrProcessStale.
+func (s *Service) GetRunningProcess() (*process.Process, error) {
+	state := s.getCachedProcessState()
+	return findProcess(state.Pid, state.StartTime)
 }
 
 func (s *Service) IsRunning() bool {
@@ -256,29 +551,73 @@ func (s *Service) IsRunning() bool {
 	return isRunning
 }
 
+// supervisorRunning reports whether the lid process supervising the service
+// is still alive.
+func (s *Service) supervisorRunning() bool {
+	state := s.getCachedProcessState()
+	_, err := findProcess(state.SupervisorPid, state.SupervisorStartTime)
+	return err == nil
+}
+
 func (s *Service) GetPid() int32 {
 	return s.getCachedProcessState().Pid
 }
 
-func (s *Service) prepareCommand(command []string) (*exec.Cmd, error) {
-	cmd := exec.Command(command[0], command[1:]...)
+// workDir returns the directory the commands of the service run in, relative
+// to the executable. It is empty for the working directory of lid.
+func (s *Service) workDir() (string, error) {
+	return resolveCwd(s.Cwd)
+}
 
-	if s.Cwd != "" {
-		cmd.Dir, _ = getRelativePath(s.Cwd)
+// envFilePaths returns the paths of the EnvFiles, relative to the Cwd, with
+// their optional suffix.
+func (s *Service) envFilePaths() []string {
+	return resolveEnvFiles(s.Cwd, s.EnvFiles)
+}
+
+func resolveCwd(cwd string) (string, error) {
+	if cwd == "" {
+		return "", nil
 	}
+	return getRelativePath(cwd)
+}
 
-	cmd.Env = os.Environ()
-	if s.EnvFile != "" {
-		envPath, _ := getRelativePath(filepath.Join(s.Cwd, s.EnvFile))
-		userDefinedEnv, err := ReadDotEnvFile(envPath)
+func resolveEnvFiles(cwd string, files []string) []string {
+	var paths []string
+	for _, file := range files {
+		path := strings.TrimSuffix(file, OPTIONAL_ENV_FILE_SUFFIX)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(cwd, path)
+		}
 
-		if err != nil {
-			return nil, err
+		path, _ = getRelativePath(path)
+		if strings.HasSuffix(file, OPTIONAL_ENV_FILE_SUFFIX) {
+			path += OPTIONAL_ENV_FILE_SUFFIX
 		}
+		paths = append(paths, path)
+	}
+	return paths
+}
 
-		cmd.Env = append(cmd.Env, userDefinedEnv...)
+func (s *Service) prepareCommand(command []string) (*exec.Cmd, error) {
+	if len(command) == 0 {
+		return nil, ErrEmptyCommand
 	}
 
+	dir, err := s.workDir()
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve the cwd %q: %w", s.Cwd, err)
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Dir = dir
+
+	env, err := s.Environment()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Env = env
+
 	return cmd, nil
 }
 
@@ -298,190 +637,587 @@ func (s *Service) PrepareStartCommand() (*exec.Cmd, error) {
 	return cmd, nil
 }
 
-func (s *Service) handleReadinessCheck(reader io.Reader, pid int32) error {
-	readinessDone := make(chan bool)
+// instance is a single process of a service. A service only has more than
+// one instance while a reload hands over from the old process to the new one.
+type instance struct {
+	cmd       *exec.Cmd
+	pid       int32
+	startTime int64 // as reported by the OS, see ServiceProcess
+	started   time.Time
+	ready     chan struct{} // closed once StdoutReadinessCheck passed
+	done      chan struct{} // closed once the process exited
+	err       error         // result of cmd.Wait, set before done is closed
+}
 
-	if s.StdoutReadinessCheck != nil {
-		s.Logger.Println("Waiting for readiness check")
-		s.WriteServiceProcess(ServiceProcess{
-			Status: STARTING,
-			Pid:    pid,
-		})
+func (inst *instance) exited() bool {
+	select {
+	case <-inst.done:
+		return true
+	default:
+		return false
+	}
+}
 
-		go func() {
-			defer close(readinessDone)
-			scanner := bufio.NewScanner(reader)
+// launch starts cmd and pipes its stdout and stderr to the service's Stdout
+// and Stderr while watching stdout for the readiness check.
+func (s *Service) launch(cmd *exec.Cmd) (*instance, error) {
+	readerStdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	readerStderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	s.Logger.Printf("Running Command: %v\n", cmd)
+
+	if s.OnBeforeStart != nil {
+		if err := s.OnBeforeStart(s); err != nil {
+			s.Logger.Printf("Rejected start: %v\n", err)
+			return nil, err
+		}
+	}
+
+	s.instanceMu.Lock()
+	s.launches++
+	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d", LID_INSTANCE_ENV, s.launches))
+	s.instanceMu.Unlock()
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start command: %v", err)
+	}
+
+	s.Logger.Printf("Started with PID: %d", cmd.Process.Pid)
 
-			readinessCheckPassed := false
-			for scanner.Scan() {
-				bytes := scanner.Bytes()
+	inst := &instance{
+		cmd:       cmd,
+		pid:       int32(cmd.Process.Pid),
+		startTime: processStartTime(int32(cmd.Process.Pid)),
+		started:   time.Now(),
+		ready:     make(chan struct{}),
+		done:      make(chan struct{}),
+	}
 
-				s.Stdout.Write(bytes)
-				s.Stdout.Write([]byte("\n"))
+	var output sync.WaitGroup
+	output.Add(2)
 
-				if s.StdoutReadinessCheck(string(bytes)) {
-					readinessCheckPassed = true
-					break
-				}
+	go func() {
+		defer output.Done()
+		ready := false
+		pipeLines(readerStdout, writerForProcess(s.Stdout, inst.pid), func(line []byte) {
+			if !ready && s.StdoutReadinessCheck != nil && s.StdoutReadinessCheck(string(line)) {
+				ready = true
+				close(inst.ready)
 			}
+		})
+	}()
+
+	go func() {
+		defer output.Done()
+		pipeLines(readerStderr, writerForProcess(s.Stderr, inst.pid), nil)
+	}()
+
+	go func() {
+		// cmd.Wait closes the pipes, so only call it once all output was read
+		output.Wait()
+		inst.err = cmd.Wait()
+		close(inst.done)
+	}()
+
+	return inst, nil
+}
+
+// pipeLines copies the lines read from r to w, calling onLine with each of
+// them, until r is closed.
+func pipeLines(r io.Reader, w io.Writer, onLine func(line []byte)) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		// one write per line, so lines of concurrent writers never mix
+		w.Write(append(line, '\n'))
+
+		if onLine != nil {
+			onLine(line)
+		}
+	}
 
-			if readinessCheckPassed {
-				s.Logger.Println(READINESS_CHECK_PASSED_MESSAGE)
-				s.WriteServiceProcess(ServiceProcess{
-					Status: RUNNING,
-					Pid:    pid,
-				})
-			} else {
+	// drain whatever the scanner gave up on so the process never blocks
+	io.Copy(w, r)
+}
+
+// waitReady waits for inst to pass the readiness check. It reports false
+// without an error if the process exited before becoming ready.
+func (s *Service) waitReady(inst *instance) (bool, error) {
+	if !s.hasReadinessCheck() {
+		s.Logger.Println(NO_READINESS_CHECK_MESSAGE)
+		return true, nil
+	}
+
+	s.Logger.Println("Waiting for readiness check")
+	timeout := time.After(s.ReadinessCheckTimeout)
+
+	if s.StdoutReadinessCheck != nil {
+		select {
+		case <-inst.ready:
+		case <-inst.done:
+			// the output is read before done is closed, so a process that
+			// became ready right before exiting already closed ready
+			select {
+			case <-inst.ready:
+			default:
 				s.Logger.Println(READINESS_CHECK_FAILED_MESSAGE)
+				return false, nil
 			}
-		}()
-	} else {
-		s.Logger.Println(NO_READINESS_CHECK_MESSAGE)
-		s.WriteServiceProcess(ServiceProcess{
-			Status: RUNNING,
-			Pid:    pid,
-		})
-		close(readinessDone)
+		case <-timeout:
+			s.Logger.Println("Readiness check timed out")
+			return false, ErrReadinessTimeout
+		}
+	}
+
+	if s.healthCheckGatesReadiness() {
+		ready, err := s.waitHealthy(inst, timeout)
+		if err != nil {
+			s.Logger.Println("Readiness check timed out")
+			return false, err
+		}
+
+		if !ready {
+			s.Logger.Println(READINESS_CHECK_FAILED_MESSAGE)
+			return false, nil
+		}
+	}
+
+	s.Logger.Println(READINESS_CHECK_PASSED_MESSAGE)
+	return true, nil
+}
+
+func (s *Service) hasReadinessCheck() bool {
+	return s.StdoutReadinessCheck != nil || s.healthCheckGatesReadiness()
+}
+
+// stopInstance sends ExitSignal to a single instance and kills it if it did
+// not exit within GracefulShutdownTimeout.
+func (s *Service) stopInstance(inst *instance) {
+	if inst.exited() {
+		return
+	}
+
+	if err := inst.cmd.Process.Signal(s.ExitSignal); err != nil {
+		s.Logger.Printf("Signal error: %v, using SIGKILL", err)
+		inst.cmd.Process.Kill()
 	}
 
 	select {
-	case <-readinessDone:
-		return nil
-	case <-time.After(s.ReadinessCheckTimeout):
-		s.Logger.Println("Readiness check timed out")
-		s.Stop()
-		return fmt.Errorf("readiness check timed out")
+	case <-inst.done:
+	case <-time.After(s.GracefulShutdownTimeout):
+		s.Logger.Printf("Graceful shutdown timeout. Killing PID %d\n", inst.pid)
+		inst.cmd.Process.Kill()
+		<-inst.done
 	}
 }
 
+func (s *Service) setCurrent(inst *instance) {
+	s.instanceMu.Lock()
+	defer s.instanceMu.Unlock()
+	s.current = inst
+}
+
+// wait blocks until the current instance exits. When a reload replaced the
+// instance, waiting moves on to its replacement.
+func (s *Service) wait(inst *instance) error {
+	for {
+		<-inst.done
+
+		s.instanceMu.Lock()
+		reloading := s.reloading
+		s.instanceMu.Unlock()
+
+		if reloading != nil {
+			<-reloading
+		}
+
+		s.instanceMu.Lock()
+		current := s.current
+		s.instanceMu.Unlock()
+
+		if current == inst {
+			return inst.err
+		}
+		inst = current
+	}
+}
+
+// Start runs the service and blocks until it exits for good, restarting it
+// according to its RestartPolicy. Only one process at a time supervises a
+// service, Start fails with ErrServiceLocked while another one does.
 func (s *Service) Start() error {
-	cmd, err := s.PrepareStartCommand()
+	unlock, err := s.lockSupervisor()
 	if err != nil {
-		s.Logger.Printf("%v\n", err)
+		if s.IsRunning() {
+			return ErrProcessAlreadyRunning
+		}
+		if errors.Is(err, ErrServiceLocked) {
+			return fmt.Errorf("%w: '%s' is supervised by another process", ErrServiceLocked, s.Name)
+		}
 		return err
 	}
+	defer unlock()
 
-	readerStdout, err := cmd.StdoutPipe()
-	if err != nil {
+	s.restarts = nil
+	s.restartCount = 0
+	s.exits = nil
+	s.forceRestart = false
+
+	if err := s.bindListeners(); err != nil {
 		s.Logger.Printf("%v\n", err)
 		return err
 	}
+	defer s.closeListeners()
 
-	readerStderr, err := cmd.StderrPipe()
+	for {
+		exitErr, err := s.run()
+		if err != nil {
+			return err
+		}
 
+		if !s.handleProcessExit(exitErr) {
+			return nil
+		}
+	}
+}
+
+// run starts the process once and waits for it to exit. The returned exitErr
+// is the result of cmd.Wait, err is set if the process could not be started.
+func (s *Service) run() (exitErr error, err error) {
+	cmd, err := s.PrepareStartCommand()
 	if err != nil {
 		s.Logger.Printf("%v\n", err)
-		return err
+		s.reportSpawn(spawnEvent{Type: SPAWN_FAILED, Reason: err.Error()})
+		return nil, err
 	}
+	s.passListeners(cmd)
 
-	reader := io.MultiReader(readerStdout, readerStderr)
-	s.Logger.Printf("Running Command: %v\n", cmd)
+	inst, err := s.launch(cmd)
+	if err != nil {
+		s.Logger.Printf("%v\n", err)
+		s.reportSpawn(spawnEvent{Type: SPAWN_FAILED, Reason: err.Error()})
+		return nil, err
+	}
+	s.setCurrent(inst)
+	s.reportSpawn(spawnEvent{Type: SPAWN_STARTED, Pid: inst.pid})
 
-	if s.OnBeforeStart != nil {
-		if err := s.OnBeforeStart(s); err != nil {
-			s.Logger.Printf("Rejected start: %v\n", err)
-			return err
-		}
+	status := RUNNING
+	if s.hasReadinessCheck() {
+		status = STARTING
 	}
 
-	if err := cmd.Start(); err != nil {
-		err = fmt.Errorf("failed to start command: %v", err)
-		s.Logger.Printf("%v\n", err)
-		return err
+	configHash, err := s.ConfigHash()
+	if err != nil {
+		s.Logger.Printf("Failed to hash the config: %v\n", err)
 	}
 
-	s.Logger.Printf("Started with PID: %d", cmd.Process.Pid)
+	s.updateServiceProcess(func(sp *ServiceProcess) {
+		sp.Status = status
+		sp.Pid = inst.pid
+		sp.ConfigHash = configHash
+		sp.StartTime = inst.startTime
+		sp.NextPid = NO_PID
+		sp.NextStartTime = 0
+		sp.SupervisorPid = int32(os.Getpid())
+		sp.SupervisorStartTime = processStartTime(int32(os.Getpid()))
+		sp.Restarts = s.restartCount
+		sp.Health = HEALTH_UNKNOWN
+		sp.HealthFailures = 0
+		if s.restartCount == 0 {
+			sp.LivenessActions = 0
+		}
+	})
 
-	if err := s.handleReadinessCheck(reader, int32(cmd.Process.Pid)); err != nil {
-		return err
+	ready, err := s.waitReady(inst)
+	if err != nil {
+		s.reportSpawn(spawnEvent{Type: SPAWN_FAILED, Pid: inst.pid, Reason: err.Error()})
+		s.Stop()
+		<-inst.done
+		return nil, err
 	}
 
-	// go io.Copy(s.Stdout, reader)
-	go io.Copy(s.Logger.Writer(), reader)
+	if ready {
+		if status == STARTING {
+			s.updateServiceProcess(func(sp *ServiceProcess) {
+				sp.Status = RUNNING
+				sp.Pid = inst.pid
+				sp.StartTime = inst.startTime
+			})
+		}
+
+		// only once the state says so, as `lid start` returns on this
+		s.reportSpawn(spawnEvent{Type: SPAWN_READY, Pid: inst.pid})
+
+		if s.HealthCheck != nil {
+			go s.monitorHealth(inst)
+		}
 
-	if s.OnAfterStart != nil {
-		s.OnAfterStart(s)
+		if s.OnAfterStart != nil {
+			s.OnAfterStart(s)
+		}
 	}
 
 	s.Logger.Println("Waiting for process to exit")
-	err = cmd.Wait()
-	s.handleProcessExit(err)
-	return nil
+	exitErr = s.wait(inst)
+
+	if !ready {
+		s.reportSpawn(spawnEvent{Type: SPAWN_EXITED, Pid: inst.pid, ExitCode: exitCodeOf(exitErr)})
+	}
+	return exitErr, nil
 }
 
-func (s *Service) handleProcessExit(err error) {
+// handleProcessExit records the exit of the process and reports whether the
+// service should be started again.
+func (s *Service) handleProcessExit(err error) bool {
 	if err != nil {
 		s.Logger.Printf("%v\n", err)
 	}
 
-	if s.getCachedProcessState().Status != STOPPED {
-		if err != nil {
-			s.Logger.Printf("Exited: %v\n", err)
+	// Stop only records STOPPED once it saw the process go away, which can be
+	// after we did
+	if status := s.getCachedProcessState().Status; status == STOPPED || status == STOPPING {
+		s.Logger.Println("Stopped")
+		return false
+	}
+
+	if err != nil {
+		s.Logger.Printf("Exited: %v\n", err)
+	} else {
+		s.Logger.Println("Exited with no error")
+	}
+
+	exitCode := exitCodeOf(err)
+	s.updateServiceProcess(func(sp *ServiceProcess) {
+		sp.Status = EXITED
+		sp.Pid = NO_PID
+		sp.StartTime = 0
+		sp.ExitCode = int32(exitCode)
+	})
+
+	if s.OnExit != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			s.OnExit(exitErr, s)
 		} else {
-			s.Logger.Println("Exited with no error")
+			s.OnExit(&exec.ExitError{}, s)
 		}
+	}
 
-		s.WriteServiceProcess(ServiceProcess{
-			Status: EXITED,
-			Pid:    NO_PID,
-		})
+	s.instanceMu.Lock()
+	forceRestart := s.forceRestart
+	s.forceRestart = false
+	s.instanceMu.Unlock()
 
-		if s.OnExit != nil {
-			if err != nil {
-				s.OnExit(err.(*exec.ExitError), s)
-			} else {
-				s.OnExit(&exec.ExitError{}, s)
-			}
+	if forceRestart {
+		s.restartCount++
+		return true
+	}
+
+	if !s.shouldRestart(exitCode) {
+		return false
+	}
+
+	if s.isCrashLooping() {
+		s.Logger.Printf("Crash loop detected: exited %d times within %s\n", len(s.exits), s.CrashLoopWindow)
+		s.setFatal()
+		return false
+	}
+
+	delay := s.restartDelay()
+	s.Logger.Printf("Restarting in %s (policy: %s)\n", delay.Round(time.Millisecond), s.RestartPolicy)
+	s.updateServiceProcess(func(sp *ServiceProcess) {
+		sp.Status = BACKOFF
+	})
+
+	if !s.sleepInBackoff(delay) {
+		s.Logger.Println("No longer in backoff, not restarting")
+		return false
+	}
+
+	s.restarts = append(s.restarts, time.Now())
+	s.restartCount++
+	return true
+}
+
+func exitCodeOf(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+
+	return -1
+}
+
+func (s *Service) shouldRestart(exitCode int) bool {
+	switch s.RestartPolicy {
+	case RestartNever:
+		return false
+	case RestartOnFailure:
+		if exitCode == 0 {
+			return false
+		}
+	}
+
+	// forget restarts that fell out of the window
+	if s.RestartWindow > 0 {
+		cutoff := time.Now().Add(-s.RestartWindow)
+		i := 0
+		for i < len(s.restarts) && s.restarts[i].Before(cutoff) {
+			i++
+		}
+		s.restarts = s.restarts[i:]
+	}
+
+	if s.RestartPolicy != RestartUnlessStopped && s.MaxRestarts > 0 && len(s.restarts) >= s.MaxRestarts {
+		s.Logger.Printf("Giving up after %d restart(s)\n", len(s.restarts))
+		s.setFatal()
+		return false
+	}
+
+	return true
+}
+
+// isCrashLooping records an exit and reports whether the service exited
+// CrashLoopThreshold times within CrashLoopWindow.
+func (s *Service) isCrashLooping() bool {
+	if s.CrashLoopThreshold < 0 {
+		return false
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-s.CrashLoopWindow)
+	i := 0
+	for i < len(s.exits) && s.exits[i].Before(cutoff) {
+		i++
+	}
+	s.exits = append(s.exits[i:], now)
+
+	return len(s.exits) >= s.CrashLoopThreshold
+}
+
+func (s *Service) setFatal() {
+	s.Logger.Println("Service is FATAL, it will not be restarted until started explicitly")
+	s.updateServiceProcess(func(sp *ServiceProcess) {
+		sp.Status = FATAL
+		sp.Pid = NO_PID
+		sp.StartTime = 0
+	})
+
+	if s.OnFatal != nil {
+		s.OnFatal(s)
+	}
+}
+
+// restartDelay doubles RestartDelay for every restart in the current window,
+// caps it at MaxRestartDelay and adds up to ±20% of jitter.
+func (s *Service) restartDelay() time.Duration {
+	delay := s.RestartDelay
+	for range s.restarts {
+		delay *= 2
+		if delay >= s.MaxRestartDelay {
+			delay = s.MaxRestartDelay
+			break
 		}
-	} else {
-		s.Logger.Println("Stopped")
 	}
+
+	jitter := (rand.Float64()*0.4 - 0.2) * float64(delay)
+	return delay + time.Duration(jitter)
+}
+
+// sleepInBackoff waits for d and returns false if the service left the
+// BACKOFF state in the meantime, e.g. through `lid stop` from another process.
+// `lid start` leaves a service in BACKOFF to this process.
+func (s *Service) sleepInBackoff(d time.Duration) bool {
+	deadline := time.Now().Add(d)
+	for time.Now().Before(deadline) {
+		if s.GetCachedStatus() != BACKOFF {
+			return false
+		}
+		time.Sleep(min(50*time.Millisecond, time.Until(deadline)))
+	}
+	return s.GetCachedStatus() == BACKOFF
+}
+
+// ClearFatal resets a FATAL service to STOPPED so it can be started again.
+func (s *Service) ClearFatal() error {
+	return s.updateServiceProcess(func(sp *ServiceProcess) {
+		if sp.Status == FATAL {
+			sp.Status = STOPPED
+		}
+	})
 }
 
 func (s *Service) Stop() error {
 	defer func() {
-		s.WriteServiceProcess(ServiceProcess{
-			Status: STOPPED,
-			Pid:    NO_PID,
+		s.updateServiceProcess(func(sp *ServiceProcess) {
+			sp.Status = STOPPED
+			sp.Pid = NO_PID
+			sp.StartTime = 0
+			sp.NextPid = NO_PID
+			sp.NextStartTime = 0
 		})
 	}()
 
+	// a reload might be starting a second process right now
+	if state := s.getCachedProcessState(); state.NextPid != NO_PID {
+		if nextProc, err := findProcess(state.NextPid, state.NextStartTime); err == nil {
+			s.Logger.Printf("Stopping PID %d that was taking over\n", state.NextPid)
+			nextProc.SendSignal(s.ExitSignal)
+		}
+	}
+
 	proc, err := s.GetRunningProcess()
 	if err != nil || proc == nil {
-		return fmt.Errorf("service already down")
+		return ErrServiceDown
 	}
 
 	running, err := proc.IsRunning()
 
 	if err == nil && !running {
-		return fmt.Errorf("service already down")
+		return ErrServiceDown
 	}
 
 	s.Logger.Println("Stopping service")
-	s.WriteServiceProcess(ServiceProcess{
-		Status: STOPPING,
-		Pid:    int32(proc.Pid),
+	s.updateServiceProcess(func(sp *ServiceProcess) {
+		sp.Status = STOPPING
+		sp.Pid = int32(proc.Pid)
 	})
 
+	sendSignal := s.ExitCommand == nil
 	if s.ExitCommand != nil {
 		s.Logger.Printf("Running exit command: %v\n", s.ExitCommand)
 
-		cmd, err := s.prepareCommand(s.ExitCommand)
-
-		cmd.Stdout = s.Logger.Writer()
-		cmd.Stderr = s.Logger.Writer()
+		// e.g. its cwd or an env file is gone
+		if cmd, err := s.prepareCommand(s.ExitCommand); err != nil {
+			s.Logger.Printf("Failed to prepare exit command: %v, sending %v instead\n", err, s.ExitSignal)
+			sendSignal = true
+		} else {
+			cmd.Stdout = s.Logger.Writer()
+			cmd.Stderr = s.Logger.Writer()
 
-		if err != nil {
-			s.Logger.Printf("Failed to run exit command: %v\n", err)
+			if err := cmd.Run(); err != nil {
+				s.Logger.Printf("Failed to run exit command: %v\n", err)
+			}
 		}
+	}
 
-		err = cmd.Run()
-		if err != nil {
-			s.Logger.Printf("Failed to run exit command: %v\n", err)
+	if sendSignal {
+		if err := proc.SendSignal(s.ExitSignal); err != nil {
+			s.Logger.Printf("Signal error: %v, using SIGKILL", err)
+			proc.Kill()
 		}
-	} else if err := proc.SendSignal(s.ExitSignal); err != nil {
-		s.Logger.Printf("Signal error: %v, using SIGKILL", err)
-		proc.Kill()
 	}
 
 	terminated := make(chan bool)
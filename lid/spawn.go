@@ -0,0 +1,147 @@
+package lid
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// SPAWN_FD_ENV tells a process started by ForkSpawn the file descriptor to
+// report the outcome of the start on.
+const SPAWN_FD_ENV = "LID_SPAWN_FD"
+
+// SPAWN_GRACE_PERIOD is how much longer than the ReadinessCheckTimeout of a
+// service the spawning process waits for the supervisor to report.
+const SPAWN_GRACE_PERIOD = 5 * time.Second
+
+type spawnEventType string
+
+const (
+	SPAWN_STARTED spawnEventType = "started" // the process was launched
+	SPAWN_READY   spawnEventType = "ready"   // the process passed its readiness check
+	SPAWN_FAILED  spawnEventType = "failed"  // the process could not be started or never became ready
+	SPAWN_EXITED  spawnEventType = "exited"  // the process exited before becoming ready
+)
+
+// spawnEvent is a message from the supervisor of a service to the process
+// that spawned it, one JSON object per line.
+type spawnEvent struct {
+	Type     spawnEventType `json:"type"`
+	Pid      int32          `json:"pid,omitempty"`
+	Reason   string         `json:"reason,omitempty"`
+	ExitCode int            `json:"exit_code,omitempty"`
+}
+
+// spawnReporter reports the outcome of the first start of a service to the
+// process that spawned its supervisor. Everything after the outcome is not
+// reported.
+type spawnReporter struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+func newSpawnReporter(file *os.File) *spawnReporter {
+	return &spawnReporter{file: file, encoder: json.NewEncoder(file)}
+}
+
+// spawnReporterFromEnv returns the reporter ForkSpawn passed to this process,
+// if any. The variable is removed and the fd closed on exec so services do not
+// inherit them: a service holding the pipe open would keep ForkSpawn waiting
+// after this process died.
+func spawnReporterFromEnv() *spawnReporter {
+	value, ok := os.LookupEnv(SPAWN_FD_ENV)
+	if !ok {
+		return nil
+	}
+	os.Unsetenv(SPAWN_FD_ENV)
+
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		return nil
+	}
+	syscall.CloseOnExec(fd)
+	return newSpawnReporter(os.NewFile(uintptr(fd), "spawn"))
+}
+
+func (r *spawnReporter) report(event spawnEvent) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return
+	}
+
+	// the spawning process might have given up waiting already
+	r.encoder.Encode(event)
+
+	if event.Type != SPAWN_STARTED {
+		r.file.Close()
+		r.file = nil
+	}
+}
+
+func (s *Service) reportSpawn(event spawnEvent) {
+	s.instanceMu.Lock()
+	reporter := s.spawnReporter
+	s.instanceMu.Unlock()
+
+	reporter.report(event)
+}
+
+// setSpawnReporter makes the next start of the service report to reporter.
+func (s *Service) setSpawnReporter(reporter *spawnReporter) {
+	s.instanceMu.Lock()
+	defer s.instanceMu.Unlock()
+	s.spawnReporter = reporter
+}
+
+// awaitSpawn reads the events reported by the supervisor of service until it
+// reports the outcome of the start, and returns an error unless the service
+// became ready. The caller closes events once it returns.
+func awaitSpawn(service *Service, events io.Reader) error {
+	outcome := make(chan error, 1)
+
+	go func() {
+		decoder := json.NewDecoder(events)
+		for {
+			var event spawnEvent
+			if err := decoder.Decode(&event); err != nil {
+				if errors.Is(err, io.EOF) {
+					err = errors.New("the supervisor exited before the service started")
+				}
+				outcome <- fmt.Errorf("%w: %v", ErrStartFailed, err)
+				return
+			}
+
+			switch event.Type {
+			case SPAWN_STARTED:
+				continue
+			case SPAWN_READY:
+				outcome <- nil
+			case SPAWN_EXITED:
+				outcome <- fmt.Errorf("%w: exited with code %d before becoming ready", ErrStartFailed, event.ExitCode)
+			default:
+				outcome <- fmt.Errorf("%w: %s", ErrStartFailed, event.Reason)
+			}
+			return
+		}
+	}()
+
+	select {
+	case err := <-outcome:
+		return err
+	case <-time.After(service.ReadinessCheckTimeout + SPAWN_GRACE_PERIOD):
+		return fmt.Errorf("%w: no answer from the supervisor after %s", ErrStartFailed, service.ReadinessCheckTimeout+SPAWN_GRACE_PERIOD)
+	}
+}
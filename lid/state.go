@@ -0,0 +1,202 @@
+package lid
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shirou/gopsutil/v4/process"
+)
+
+// STATE_VERSION is the version of the state file format written by lid. It is
+// bumped whenever a change would make older versions misread the file.
+const STATE_VERSION = 1
+
+// LEGACY_STATE_SIZE is the size of a state file written before the format was
+// versioned: the status and the PID, in binary.
+const LEGACY_STATE_SIZE = 5
+
+type stateFile struct {
+	Version int `json:"version"`
+	ServiceProcess
+}
+
+func readLegacyServiceProcess(data []byte) (ServiceProcess, error) {
+	var legacy struct {
+		Status ServiceStatus
+		Pid    int32
+	}
+
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &legacy); err != nil {
+		return ServiceProcess{}, fmt.Errorf("%w: %v", ErrProcessCorrupt, err)
+	}
+
+	// the legacy format knew no status after STOPPING
+	if legacy.Status < STOPPED || legacy.Status > STOPPING || legacy.Pid < 0 {
+		return ServiceProcess{}, fmt.Errorf("%w: invalid legacy state", ErrProcessCorrupt)
+	}
+
+	return ServiceProcess{Status: legacy.Status, Pid: legacy.Pid}, nil
+}
+
+// defaultNamespace names the project of the running executable, e.g.
+// "myapp-1a2b3c4d". The hash of the full executable path keeps two checkouts
+// of the same project apart.
+func defaultNamespace() string {
+	execPath, err := os.Executable()
+	if err != nil {
+		execPath = os.Args[0]
+	}
+
+	if resolved, err := filepath.EvalSymlinks(execPath); err == nil {
+		execPath = resolved
+	}
+
+	hash := sha256.Sum256([]byte(execPath))
+	return fmt.Sprintf("%s-%s", sanitizeNamespace(filepath.Base(execPath)), hex.EncodeToString(hash[:4]))
+}
+
+func sanitizeNamespace(namespace string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, namespace)
+}
+
+// defaultStateDir returns $XDG_STATE_HOME/lid/<namespace>, falling back to
+// ~/.local/state and then to the temp directory.
+func defaultStateDir(namespace string) string {
+	if namespace == "" {
+		namespace = defaultNamespace()
+	}
+
+	base := os.Getenv("XDG_STATE_HOME")
+	if base == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			base = filepath.Join(home, ".local", "state")
+		} else {
+			base = os.TempDir()
+		}
+	}
+
+	return filepath.Join(base, "lid", sanitizeNamespace(namespace))
+}
+
+// legacyServiceProcessFilename is where lid kept the state of a service before
+// state directories existed.
+func legacyServiceProcessFilename(name string) string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("service-%s.lid", name))
+}
+
+// migrateLegacyState moves the state a previous lid version left in the temp
+// directory into the state directory, keeping the status and exit code of the
+// service. Its PID came without a start time, so findProcess never trusts it.
+// It does nothing once the service has state of its own.
+func (s *Service) migrateLegacyState() (bool, error) {
+	legacy := legacyServiceProcessFilename(s.Name)
+	filename := s.GetServiceProcessFilename()
+
+	if legacy == filename {
+		return false, nil
+	}
+
+	if _, err := os.Stat(filename); err == nil {
+		return false, nil
+	}
+
+	if _, err := os.Stat(legacy); err != nil {
+		return false, nil
+	}
+
+	// the temp directory is often on another file system
+	if err := os.Rename(legacy, filename); err == nil {
+		return true, nil
+	}
+
+	src, err := os.Open(legacy)
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(filename)
+		return false, err
+	}
+
+	if err := dst.Close(); err != nil {
+		return false, err
+	}
+
+	return true, os.Remove(legacy)
+}
+
+// processStartTime returns when the OS started pid, in milliseconds since the
+// epoch, or 0 if it cannot tell.
+func processStartTime(pid int32) int64 {
+	proc, err := process.NewProcess(pid)
+	if err != nil {
+		return 0
+	}
+
+	createTime, err := proc.CreateTime()
+	if err != nil {
+		return 0
+	}
+	return createTime
+}
+
+// findProcess returns the running process with pid, as long as it is the one
+// that started at startTime. State written before start times were recorded
+// has a startTime of 0: its PID cannot be told apart from one reused by an
+// unrelated program, so it is taken as stale.
+func findProcess(pid int32, startTime int64) (*process.Process, error) {
+	if pid == NO_PID {
+		return nil, ErrProcessNotFound
+	}
+
+	proc, err := process.NewProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	running, err := proc.IsRunning()
+	if err != nil {
+		return nil, err
+	}
+
+	if !running {
+		return nil, ErrProcessNotFound
+	}
+
+	if startTime == 0 {
+		return nil, ErrProcessStale
+	}
+
+	createTime, err := proc.CreateTime()
+	if err != nil {
+		return nil, err
+	}
+
+	if createTime != startTime {
+		return nil, ErrProcessStale
+	}
+
+	return proc, nil
+}
@@ -0,0 +1,147 @@
+package lid
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// serviceInfoJSON is how --json prints a ServiceInfo: durations in seconds,
+// and the start time, exit code and health only when they are meaningful.
+type serviceInfoJSON struct {
+	Name      string        `json:"name"`
+	Status    ServiceStatus `json:"status"`
+	Pid       int32         `json:"pid,omitempty"`
+	StartTime *time.Time    `json:"start_time,omitempty"`
+	Uptime    float64       `json:"uptime"`
+	CPU       float64       `json:"cpu"`
+	MemoryRSS uint64        `json:"memory_rss"`
+	Restarts  int32         `json:"restarts"`
+	ExitCode  *int32        `json:"exit_code,omitempty"`
+	Health    *HealthStatus `json:"health,omitempty"`
+	Command   []string      `json:"command"`
+	Cwd       string        `json:"cwd,omitempty"`
+	EnvFiles  []string      `json:"env_files,omitempty"`
+}
+
+func (info ServiceInfo) MarshalJSON() ([]byte, error) {
+	out := serviceInfoJSON{
+		Name:      info.Name,
+		Status:    info.Status,
+		Pid:       info.Pid,
+		Uptime:    info.Uptime.Seconds(),
+		CPU:       info.CPU,
+		MemoryRSS: info.MemoryRSS,
+		Restarts:  info.Restarts,
+		Command:   info.Command,
+		Cwd:       info.Cwd,
+		EnvFiles:  info.EnvFiles,
+	}
+
+	if !info.StartTime.IsZero() {
+		out.StartTime = &info.StartTime
+	}
+	if info.HasExited {
+		out.ExitCode = &info.ExitCode
+	}
+	if info.HasHealthCheck {
+		out.Health = &info.Health
+	}
+
+	return json.Marshal(out)
+}
+
+func (info *ServiceInfo) UnmarshalJSON(data []byte) error {
+	var in serviceInfoJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	*info = ServiceInfo{
+		Name:      in.Name,
+		Status:    in.Status,
+		Pid:       in.Pid,
+		Uptime:    time.Duration(in.Uptime * float64(time.Second)),
+		CPU:       in.CPU,
+		MemoryRSS: in.MemoryRSS,
+		Restarts:  in.Restarts,
+		Command:   in.Command,
+		Cwd:       in.Cwd,
+		EnvFiles:  in.EnvFiles,
+	}
+
+	if in.StartTime != nil {
+		info.StartTime = *in.StartTime
+	}
+	if in.ExitCode != nil {
+		info.ExitCode, info.HasExited = *in.ExitCode, true
+	}
+	if in.Health != nil {
+		info.Health, info.HasHealthCheck = *in.Health, true
+	}
+	return nil
+}
+
+// IsUp reports whether the service runs, which `lid status` exits with 0 for.
+func (info ServiceInfo) IsUp() bool {
+	return info.Status == RUNNING
+}
+
+// renderStatus prints the details of a service for `lid status`.
+func renderStatus(info ServiceInfo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	row := func(label, value string) {
+		fmt.Fprintf(w, "%s:\t%s\n", label, value)
+	}
+
+	row("Name", info.Name)
+	row("Status", statusLabel(info.Status))
+
+	if info.Pid != NO_PID {
+		row("PID", fmt.Sprintf("%d", info.Pid))
+		row("Started", fmt.Sprintf("%s (%s ago)", info.StartTime.Format(time.DateTime), info.Uptime.Round(time.Second)))
+		row("CPU", fmt.Sprintf("%f%%", info.CPU))
+		row("Memory", fmt.Sprintf("%.2fMB", float64(info.MemoryRSS)/1024/1024))
+	}
+
+	row("Command", shellJoin(info.Command))
+	row("Cwd", valueOrDash(info.Cwd))
+	row("Env files", valueOrDash(strings.Join(info.EnvFiles, " ")))
+	row("Restarts", fmt.Sprintf("%d", info.Restarts))
+
+	lastExit := "-"
+	if info.HasExited {
+		lastExit = fmt.Sprintf("%d", info.ExitCode)
+	}
+	row("Last exit", lastExit)
+
+	health := "-"
+	if info.HasHealthCheck {
+		health = healthLabel(info.Health)
+	}
+	row("Health", health)
+
+	w.Flush()
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// shellJoin renders a command the way it could be typed into a shell.
+func shellJoin(command []string) string {
+	args := make([]string, len(command))
+	for i, arg := range command {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$`&|;<>()*?[]{}~#!") {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		args[i] = arg
+	}
+	return strings.Join(args, " ")
+}
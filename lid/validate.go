@@ -0,0 +1,252 @@
+package lid
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ConfigError is a problem with the config of a service.
+type ConfigError struct {
+	Service string // Empty if the problem is not about one service
+	Field   string // The field of ServiceConfig the problem is in, if any
+	Err     error
+}
+
+func (e *ConfigError) Error() string {
+	var b strings.Builder
+	if e.Service != "" {
+		fmt.Fprintf(&b, "service '%s': ", e.Service)
+	}
+	if e.Field != "" {
+		fmt.Fprintf(&b, "%s: ", e.Field)
+	}
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ConfigError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Service string `json:"service,omitempty"`
+		Field   string `json:"field,omitempty"`
+		Error   string `json:"error"`
+	}{e.Service, e.Field, e.Err.Error()})
+}
+
+// ConfigErrors are all the problems Validate found, one per line.
+type ConfigErrors []*ConfigError
+
+func (errs ConfigErrors) Error() string {
+	lines := make([]string, len(errs))
+	for i, err := range errs {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (errs ConfigErrors) Unwrap() []error {
+	unwrapped := make([]error, len(errs))
+	for i, err := range errs {
+		unwrapped[i] = err
+	}
+	return unwrapped
+}
+
+// err returns errs as an error, or nil if there are none.
+func (errs ConfigErrors) err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Validate checks that the service can be started from here: that its
+// commands are not empty and their programs exist, and that its Cwd and env
+// files exist and the env files parse. Programs without a slash are looked up
+// in the PATH of lid. It returns ConfigErrors with every problem it found.
+// Dependencies are checked by Lid.Validate, which knows the other services.
+func (c ServiceConfig) Validate() error {
+	var errs ConfigErrors
+	problem := func(field string, err error) {
+		errs = append(errs, &ConfigError{Field: field, Err: err})
+	}
+
+	cwd, err := resolveCwd(c.Cwd)
+	if err != nil {
+		problem("Cwd", err)
+	} else if cwd != "" {
+		if info, err := os.Stat(cwd); err != nil {
+			problem("Cwd", err)
+		} else if !info.IsDir() {
+			problem("Cwd", fmt.Errorf("%s is not a directory", cwd))
+		}
+	}
+
+	if err := checkCommand(c.Command, cwd); err != nil {
+		problem("Command", err)
+	}
+
+	if c.ExitCommand != nil {
+		if err := checkCommand(c.ExitCommand, cwd); err != nil {
+			problem("ExitCommand", err)
+		}
+	}
+
+	if c.HealthCheck != nil && c.HealthCheck.Exec != nil {
+		if err := checkCommand(c.HealthCheck.Exec, cwd); err != nil {
+			problem("HealthCheck.Exec", err)
+		}
+	}
+
+	envFiles := c.EnvFiles
+	if c.EnvFile != "" {
+		envFiles = append([]string{c.EnvFile}, envFiles...)
+	}
+
+	for _, path := range resolveEnvFiles(c.Cwd, envFiles) {
+		optional := strings.HasSuffix(path, OPTIONAL_ENV_FILE_SUFFIX)
+		path = strings.TrimSuffix(path, OPTIONAL_ENV_FILE_SUFFIX)
+
+		_, err := readEnvFile(path, nil)
+		if err != nil && !(optional && errors.Is(err, fs.ErrNotExist)) {
+			problem("EnvFiles", err)
+		}
+	}
+
+	for _, entry := range c.Env {
+		if !strings.Contains(entry, "=") {
+			problem("Env", fmt.Errorf("%q is not KEY=value", entry))
+		}
+	}
+
+	return errs.err()
+}
+
+// checkCommand checks that command is not empty and its program can be run
+// from dir.
+func checkCommand(command []string, dir string) error {
+	if len(command) == 0 {
+		return ErrEmptyCommand
+	}
+
+	program := command[0]
+	if !strings.Contains(program, "/") {
+		_, err := exec.LookPath(program)
+		return err
+	}
+
+	if !filepath.IsAbs(program) {
+		program = filepath.Join(dir, program)
+	}
+
+	info, err := os.Stat(program)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return fmt.Errorf("%s is not executable", program)
+	}
+	return nil
+}
+
+// config returns the parts of the ServiceConfig of the service Validate
+// checks.
+func (s *Service) config() ServiceConfig {
+	return ServiceConfig{
+		Cwd:         s.Cwd,
+		Command:     s.Command,
+		EnvFiles:    s.EnvFiles,
+		Env:         s.Env,
+		ExitCommand: s.ExitCommand,
+		HealthCheck: s.HealthCheck,
+		DependsOn:   s.DependsOn,
+	}
+}
+
+// Validate checks the config of the given services (or all of them) like
+// ServiceConfig.Validate, and that they only depend on registered services
+// and not on themselves through a cycle. It returns ConfigErrors with every
+// problem it found, starting with the services Register rejected.
+func (lid *Lid) Validate(services []string) error {
+	var errs ConfigErrors
+	for _, err := range lid.registerErrors {
+		if len(services) == 0 || contains(services, err.Service) {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, name := range lid.selectServices(services) {
+		service := lid.services[name]
+
+		var serviceErrs ConfigErrors
+		errors.As(service.config().Validate(), &serviceErrs)
+		for _, err := range serviceErrs {
+			err.Service = name
+			errs = append(errs, err)
+		}
+
+		for _, dep := range service.DependsOn {
+			if _, ok := lid.services[dep]; !ok {
+				errs = append(errs, &ConfigError{
+					Service: name,
+					Field:   "DependsOn",
+					Err:     fmt.Errorf("unknown service '%s'", dep),
+				})
+			}
+		}
+	}
+
+	if err := lid.dependencyCycle(); err != nil {
+		errs = append(errs, &ConfigError{Field: "DependsOn", Err: err})
+	}
+
+	return errs.err()
+}
+
+// runCheck runs `lid check`, printing every problem with the config of the
+// given services (or all of them).
+func (lid *Lid) runCheck(args []string) {
+	var printJSON bool
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	flags.BoolVar(&printJSON, "json", false, "print a JSON object per problem")
+
+	services, err := parseArgs(flags, args)
+	if err != nil {
+		os.Exit(EXIT_USAGE)
+	}
+
+	for _, name := range services {
+		if _, ok := lid.services[name]; !ok {
+			fmt.Fprintf(os.Stderr, "Service '%s' not found\n", name)
+			os.Exit(EXIT_USAGE)
+		}
+	}
+
+	var errs ConfigErrors
+	errors.As(lid.Validate(services), &errs)
+
+	switch {
+	case printJSON:
+		exitOnPrintError(printEach(errs, commandOptions{JSON: true}))
+	case len(errs) == 0:
+		fmt.Fprintf(os.Stderr, "%d services OK\n", len(lid.selectServices(services)))
+	default:
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+	}
+
+	if len(errs) > 0 {
+		os.Exit(EXIT_FAILURE)
+	}
+}
@@ -0,0 +1,90 @@
+// Package listen picks up the listening sockets lid passes to the services it
+// manages, so they keep accepting connections while lid reloads them.
+//
+// lid follows the systemd socket activation convention: listeners are passed
+// as file descriptors starting at 3, LISTEN_FDS holds their count and
+// LISTEN_PID the pid they are meant for. Services started by systemd can use
+// this package as well.
+//
+//	l, err := listen.Listen(0, "tcp", ":8080")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	http.Serve(l, handler)
+package listen
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+const listenFdsStart = 3
+
+var (
+	once         sync.Once
+	listeners    []net.Listener
+	listenersErr error
+)
+
+// Listeners returns the listeners passed to this process, in the order they
+// are configured in the service's Listeners. It returns no listeners when the
+// process was not started with any.
+func Listeners() ([]net.Listener, error) {
+	once.Do(func() {
+		listeners, listenersErr = inherit()
+	})
+	return listeners, listenersErr
+}
+
+// Listen returns the index-th inherited listener, or listens on network and
+// address itself when the process was not passed one, e.g. while running it
+// outside of lid during development.
+func Listen(index int, network, address string) (net.Listener, error) {
+	inherited, err := Listeners()
+	if err != nil {
+		return nil, err
+	}
+
+	if index < len(inherited) {
+		return inherited[index], nil
+	}
+
+	return net.Listen(network, address)
+}
+
+func inherit() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %w", err)
+	}
+
+	// the listeners are meant for this process only, not for its children
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	inherited := make([]net.Listener, 0, count)
+	for fd := listenFdsStart; fd < listenFdsStart+count; fd++ {
+		syscall.CloseOnExec(fd)
+
+		file := os.NewFile(uintptr(fd), fmt.Sprintf("listener-%d", fd-listenFdsStart))
+		l, err := net.FileListener(file)
+		file.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("file descriptor %d is not a listener: %w", fd, err)
+		}
+		inherited = append(inherited, l)
+	}
+
+	return inherited, nil
+}
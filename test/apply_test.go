@@ -0,0 +1,176 @@
+package lid_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robo-monk/lid/lid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// applying runs `lid apply` with only the services of lid.yaml, keeping the
+// plan on stdout apart from the logs.
+var applying = testLidOptions{Env: []string{"LID_TEST_CONFIG_ONLY=1"}, StdoutOnly: true}
+
+// applyPlan returns the plan `lid apply` printed before the logs of the
+// services as "name action reason" lines.
+func applyPlan(output string) []string {
+	var plan []string
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if strings.HasPrefix(line, "[") {
+			break
+		}
+		plan = append(plan, strings.Join(strings.Fields(line), " "))
+	}
+	return plan
+}
+
+// servicePid returns the PID the state of the service records.
+func servicePid(t *testing.T, stateDir, name string) int32 {
+	sp, err := lid.ReadServiceProcess(filepath.Join(stateDir, "service-"+name+".lid"))
+	require.NoError(t, err)
+	return sp.Pid
+}
+
+func TestApply(t *testing.T) {
+	stateDir := t.TempDir()
+	defer killStartedProcesses(t, stateDir)
+
+	writeServices := func(content string) {
+		require.NoError(t, os.WriteFile(filepath.Join(stateDir, "lid.yaml"), []byte("services:\n"+content), 0644))
+	}
+	api := `
+  api:
+    command: [bash, -c, 'echo $$ >> pids; echo "api ready"; exec sleep 30']
+    env: {PORT: "%s"}
+    readiness_pattern: 'api ready'
+    restart_policy: %s
+`
+	worker := `
+  worker:
+    command: [bash, -c, 'echo $$ >> pids; echo "worker ready"; exec sleep 30']
+    readiness_pattern: 'worker ready'
+`
+	apiWith := func(port, policy string) string {
+		return fmt.Sprintf(api, port, policy)
+	}
+
+	writeServices(apiWith("8080", "never") + worker)
+	output, code := runTestLidOutput(t, stateDir, applying, "apply")
+	require.Equal(t, lid.EXIT_OK, code)
+	assert.Equal(t, []string{"api start added", "worker start added"}, applyPlan(output))
+	require.Len(t, runningPids(t, stateDir), 2)
+	started := startedPids(t, stateDir)
+	apiPid, workerPid := servicePid(t, stateDir, "api"), servicePid(t, stateDir, "worker")
+
+	// only the Command, Cwd and environment count as changes
+	writeServices(apiWith("8080", "always") + worker)
+	output, code = runTestLidOutput(t, stateDir, applying, "apply", "--dry-run")
+	require.Equal(t, lid.EXIT_OK, code)
+	assert.Equal(t, []string{"api keep unchanged", "worker keep unchanged"}, applyPlan(output))
+
+	writeServices(apiWith("9090", "always") + worker)
+	output, code = runTestLidOutput(t, stateDir, applying, "apply", "--dry-run")
+	require.Equal(t, lid.EXIT_OK, code)
+	assert.Equal(t, []string{"api restart config changed", "worker keep unchanged"}, applyPlan(output))
+	assert.Equal(t, started, runningPids(t, stateDir), "A dry run should not touch the services")
+
+	output, code = runTestLidOutput(t, stateDir, applying, "apply")
+	require.Equal(t, lid.EXIT_OK, code)
+	assert.Equal(t, []string{"api restart config changed", "worker keep unchanged"}, applyPlan(output))
+	require.Len(t, runningPids(t, stateDir), 2)
+	assert.NotEqual(t, apiPid, servicePid(t, stateDir, "api"), "The api should have been restarted")
+	assert.Equal(t, workerPid, servicePid(t, stateDir, "worker"), "The worker should have been kept")
+
+	writeServices(apiWith("9090", "always"))
+	output, code = runTestLidOutput(t, stateDir, applying, "apply", "--json")
+	require.Equal(t, lid.EXIT_OK, code)
+	var steps []lid.ApplyStep
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		var step lid.ApplyStep
+		require.NoError(t, json.Unmarshal([]byte(line), &step))
+		steps = append(steps, step)
+	}
+	assert.Equal(t, []lid.ApplyStep{
+		{Name: "api", Action: lid.APPLY_KEEP, Reason: "unchanged"},
+		{Name: "worker", Action: lid.APPLY_STOP, Reason: "removed"},
+	}, steps)
+	assert.NotContains(t, runningPids(t, stateDir), workerPid, "The removed worker should have been stopped")
+	assert.NoFileExists(t, filepath.Join(stateDir, "service-worker.lid"), "The removed worker should have been forgotten")
+
+	output, code = runTestLidOutput(t, stateDir, applying, "apply")
+	require.Equal(t, lid.EXIT_OK, code)
+	assert.Equal(t, []string{"api keep unchanged"}, applyPlan(output))
+
+	writeServices(apiWith("9090", "always") + worker)
+	output, code = runTestLidOutput(t, stateDir, applying, "apply")
+	require.Equal(t, lid.EXIT_OK, code)
+	assert.Equal(t, []string{"api keep unchanged", "worker start added"}, applyPlan(output))
+	assert.Len(t, runningPids(t, stateDir), 2, "The re-added worker should have been started")
+
+	// a service stopped on purpose stays stopped
+	_, code = runTestLidOutput(t, stateDir, testLidOptions{}, "stop", "worker")
+	require.Equal(t, lid.EXIT_OK, code)
+	output, code = runTestLidOutput(t, stateDir, applying, "apply")
+	require.Equal(t, lid.EXIT_OK, code)
+	assert.Equal(t, []string{"api keep unchanged", "worker keep stopped"}, applyPlan(output))
+	assert.Len(t, runningPids(t, stateDir), 1, "The stopped worker should not have been started")
+
+	_, code = runTestLidOutput(t, stateDir, testLidOptions{}, "stop")
+	assert.Equal(t, lid.EXIT_OK, code)
+	assert.Empty(t, runningPids(t, stateDir))
+}
+
+func TestApplyKeepsFatalServices(t *testing.T) {
+	stateDir := t.TempDir()
+	defer killStartedProcesses(t, stateDir)
+
+	require.NoError(t, os.WriteFile(filepath.Join(stateDir, "lid.yaml"), []byte(`
+services:
+  worker:
+    command: [bash, -c, 'echo $$ >> pids; exec sleep 30']
+`), 0644))
+	stateFile := filepath.Join(stateDir, "service-worker.lid")
+	require.NoError(t, lid.ServiceProcess{Status: lid.FATAL, Pid: lid.NO_PID, ExitCode: 1}.WriteToFile(stateFile))
+
+	output, code := runTestLidOutput(t, stateDir, applying, "apply")
+	require.Equal(t, lid.EXIT_OK, code)
+	assert.Equal(t, []string{"worker keep fatal"}, applyPlan(output))
+	assert.Empty(t, startedPids(t, stateDir), "The FATAL worker should not have been started")
+
+	state, err := lid.ReadServiceProcess(stateFile)
+	require.NoError(t, err)
+	assert.Equal(t, lid.FATAL, state.Status, "Only `lid start` should clear FATAL")
+}
+
+func TestConfigHash(t *testing.T) {
+	dir := t.TempDir()
+	hash := func(config lid.ServiceConfig) string {
+		_, s := NewTestService(t, config)
+		h, err := s.ConfigHash()
+		require.NoError(t, err)
+		return h
+	}
+
+	base := lid.ServiceConfig{Command: []string{"sleep", "30"}, Cwd: dir, Env: []string{"PORT=8080"}}
+	assert.Equal(t, hash(base), hash(base))
+
+	for name, change := range map[string]func(*lid.ServiceConfig){
+		"command": func(c *lid.ServiceConfig) { c.Command = []string{"sleep", "60"} },
+		"cwd":     func(c *lid.ServiceConfig) { c.Cwd = t.TempDir() },
+		"env":     func(c *lid.ServiceConfig) { c.Env = []string{"PORT=9090"} },
+	} {
+		changed := base
+		change(&changed)
+		assert.NotEqual(t, hash(base), hash(changed), name)
+	}
+
+	unchanged := base
+	unchanged.RestartPolicy = lid.RestartAlways
+	assert.Equal(t, hash(base), hash(unchanged), "Only what the process is started with should count")
+}
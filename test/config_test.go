@@ -0,0 +1,191 @@
+package lid_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/robo-monk/lid/lid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeConfig writes a config file into dir and loads it.
+func writeConfig(t *testing.T, dir, name, content string) (*lid.Config, error) {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return lid.LoadConfig(path)
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	config, err := writeConfig(t, dir, "lid.yaml", `
+services:
+  api:
+    command: ["./api", "--port", "8080"]
+    cwd: api
+    inherit_env: false
+    env_files: [.env, ".env.local?"]
+    env:
+      PORT: "8080"
+      LOG_LEVEL: debug
+    graceful_shutdown_timeout: 10s
+    readiness_check_timeout: 1m
+    readiness_pattern: 'listening on :\d+'
+    exit_signal: int
+    depends_on: [db]
+    restart_policy: on-failure
+    max_restarts: 5
+    restart_window: 1m
+    log_rotation:
+      max_size: 1048576
+      compress: true
+    health_check:
+      http:
+        url: http://localhost:8080/health
+      interval: 5s
+      on_failure: restart
+      failure_signal: SIGTERM
+  db:
+    command: [postgres]
+    cwd: /var/lib/postgres
+    exit_command: [pg_ctl, stop]
+`)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "lid.yaml"), config.Path)
+	require.Len(t, config.Services, 2)
+
+	api := config.Services["api"]
+	assert.Equal(t, []string{"./api", "--port", "8080"}, api.Command)
+	assert.Equal(t, filepath.Join(dir, "api"), api.Cwd, "A relative cwd should be relative to the config file")
+	assert.False(t, *api.InheritEnv)
+	assert.Equal(t, []string{".env", ".env.local?"}, api.EnvFiles)
+	assert.Equal(t, []string{"LOG_LEVEL=debug", "PORT=8080"}, api.Env)
+	assert.Equal(t, 10*time.Second, api.GracefulShutdownTimeout)
+	assert.Equal(t, time.Minute, api.ReadinessCheckTimeout)
+	assert.True(t, api.StdoutReadinessCheck("listening on :8080"))
+	assert.False(t, api.StdoutReadinessCheck("starting"))
+	assert.Equal(t, syscall.SIGINT, api.ExitSignal)
+	assert.Equal(t, []string{"db"}, api.DependsOn)
+	assert.Equal(t, lid.RestartOnFailure, api.RestartPolicy)
+	assert.Equal(t, 5, api.MaxRestarts)
+	assert.Equal(t, time.Minute, api.RestartWindow)
+	assert.Equal(t, &lid.LogRotation{MaxSize: 1 << 20, Compress: true}, api.LogRotation)
+	assert.Equal(t, &lid.HealthCheck{
+		HTTP:          &lid.HTTPProbe{URL: "http://localhost:8080/health"},
+		Interval:      5 * time.Second,
+		OnFailure:     lid.LivenessRestart,
+		FailureSignal: syscall.SIGTERM,
+	}, api.HealthCheck)
+
+	db := config.Services["db"]
+	assert.Equal(t, "/var/lib/postgres", db.Cwd)
+	assert.Equal(t, []string{"pg_ctl", "stop"}, db.ExitCommand)
+	assert.Nil(t, db.InheritEnv, "An unset inherit_env should keep the default")
+	assert.Nil(t, db.StdoutReadinessCheck)
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	dir := t.TempDir()
+	expected := lid.ServiceConfig{
+		Command:         []string{"./worker"},
+		Cwd:             dir,
+		Env:             []string{"DEBUG=true", "PORT=8080", "QUEUE=jobs"},
+		ExitSignal:      syscall.SIGQUIT,
+		RestartPolicy:   lid.RestartAlways,
+		MaxRestartDelay: time.Minute,
+	}
+
+	for name, content := range map[string]string{
+		"lid.yml": `
+services:
+  worker:
+    command: [./worker]
+    env: {QUEUE: jobs, PORT: 8080, DEBUG: true}
+    exit_signal: SIGQUIT
+    restart_policy: always
+    max_restart_delay: 1m
+`,
+		"lid.toml": `
+[services.worker]
+command = ["./worker"]
+env = { QUEUE = "jobs", PORT = 8080, DEBUG = true }
+exit_signal = "SIGQUIT"
+restart_policy = "always"
+max_restart_delay = "1m"
+`,
+		"lid.json": `{
+	"services": {
+		"worker": {
+			"command": ["./worker"],
+			"env": {"QUEUE": "jobs", "PORT": 8080, "DEBUG": true},
+			"exit_signal": "SIGQUIT",
+			"restart_policy": "always",
+			"max_restart_delay": "1m"
+		}
+	}
+}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			config, err := writeConfig(t, dir, name, content)
+			require.NoError(t, err)
+			assert.Equal(t, map[string]lid.ServiceConfig{"worker": expected}, config.Services)
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	for content, expected := range map[string]string{
+		"services:\n  api:\n    comand: [./api]\n":                  `unknown field "comand"`,
+		"services:\n  api:\n    restart_window: soon\n":             `invalid duration "soon"`,
+		"services:\n  api:\n    restart_policy: sometimes\n":        `unknown restart policy "sometimes"`,
+		"services:\n  api:\n    exit_signal: SIGNOPE\n":             `service 'api': exit_signal: unknown signal "SIGNOPE"`,
+		"services:\n  api:\n    readiness_pattern: '('\n":           `service 'api': readiness_pattern: error parsing regexp`,
+		"services:\n  api:\n    health_check: {on_failure: hook}\n": `service 'api': health_check: on_failure: hook needs an OnFailureHook`,
+		"services:\n  api:\n    command: ./api\n":                   `cannot unmarshal string`,
+		"services:\n  api:\n    env: {PORTS: [8080]}\n":             `env value [8080] is not a string, number or boolean`,
+		"services:\n  api: [\n":                                     `yaml:`,
+	} {
+		_, err := writeConfig(t, dir, "lid.yaml", content)
+		assert.ErrorContains(t, err, expected, content)
+		assert.ErrorContains(t, err, filepath.Join(dir, "lid.yaml")+": ", "The error should name the file")
+	}
+
+	_, err := writeConfig(t, dir, "lid.ini", "")
+	assert.ErrorContains(t, err, `unknown config format ".ini"`)
+
+	_, err = lid.LoadConfig(filepath.Join(dir, "missing.yaml"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestConfigServicesMixWithGoServices(t *testing.T) {
+	stateDir := t.TempDir()
+	defer killStartedProcesses(t, stateDir)
+
+	// depends on the backend registered in Go by runTestLid
+	require.NoError(t, os.WriteFile(filepath.Join(stateDir, "lid.yaml"), []byte(`
+services:
+  worker:
+    command: [bash, -c, 'echo $$ >> pids; echo "worker ready on $PORT"; exec sleep 30']
+    env: {PORT: "8080"}
+    readiness_pattern: 'ready on \d+$'
+    depends_on: [backend]
+`), 0644))
+
+	output, code := runTestLidOutput(t, stateDir, testLidOptions{}, "start", "worker")
+	require.Equal(t, lid.EXIT_OK, code, output)
+	assert.Len(t, startedPids(t, stateDir), 2, "The worker and the backend should have been started")
+
+	output, code = runTestLidOutput(t, stateDir, testLidOptions{StdoutOnly: true}, "env", "worker")
+	assert.Equal(t, lid.EXIT_OK, code)
+	assert.Contains(t, strings.Split(output, "\n"), "PORT=8080")
+
+	_, code = runTestLidOutput(t, stateDir, testLidOptions{}, "stop")
+	assert.Equal(t, lid.EXIT_OK, code)
+	assert.Empty(t, runningPids(t, stateDir))
+}
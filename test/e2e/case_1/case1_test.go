@@ -1,18 +1,20 @@
 package case1_test
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
-	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/robo-monk/lid/lid"
 	"github.com/shirou/gopsutil/v4/process"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -29,84 +31,57 @@ require github.com/robo-monk/lid v0.0.0
 	require.NoError(t, err)
 }
 
-func getProcessList(t *testing.T) string {
-	output := runCmd(t, "./case1", "list")
-	fmt.Println("\n output: ", output)
-	return output
-}
-
-type ProcessInfo struct {
-	Status string
-	Uptime string
-	PID    int
-	CPU    string
-	Memory string
-}
+// getProcessList returns the status of every service as printed by
+// `list --json`.
+func getProcessList(t *testing.T) []lid.ServiceInfo {
+	cmd := exec.Command("./case1", "list", "--json")
+	cmd.Dir = filepath.Join("testdata")
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	fmt.Println("\n output: ", string(output))
+
+	// unstable-service shows up as exited between a crash and its restart
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != lid.EXIT_NOT_RUNNING {
+		require.NoError(t, err)
+	}
 
-func TrimAnsi(s string) string {
-	ansiRegex := regexp.MustCompile("\x1b\\[[0-9;]*[mGKH]")
-	return ansiRegex.ReplaceAllString(s, "")
+	var infos []lid.ServiceInfo
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	for decoder.More() {
+		var info lid.ServiceInfo
+		require.NoError(t, decoder.Decode(&info))
+		infos = append(infos, info)
+	}
+	return infos
 }
 
-func GetProcessInfoByName(output, procName string) (*ProcessInfo, error) {
-	// This regex attempts to match a line structured as:
-	// │ Name │ Status │ Uptime │ PID │ CPU │ Memory │
-	// Each column is captured into a named group. We are using (?P<...>) syntax for named groups.
-	regexPattern := `^\s*│\s*(?P<Name>[^│]+)\s*│\s*(?P<Status>[^│]+)\s*│\s*(?P<Uptime>[^│]+)\s*│\s*(?P<PID>[^│]+)\s*│\s*(?P<CPU>[^│]+)\s*│\s*(?P<Memory>[^│]+)\s*│\s*$`
-	re := regexp.MustCompile(regexPattern)
-
-	lines := strings.Split(output, "\n")
-	for _, line := range lines {
-		match := re.FindStringSubmatch(line)
-		if match == nil {
-			continue
-		}
-
-		// Extract the indices for named captures
-		nameIdx := re.SubexpIndex("Name")
-		statusIdx := re.SubexpIndex("Status")
-		uptimeIdx := re.SubexpIndex("Uptime")
-		pidIdx := re.SubexpIndex("PID")
-		cpuIdx := re.SubexpIndex("CPU")
-		memoryIdx := re.SubexpIndex("Memory")
-
-		name := strings.TrimSpace(match[nameIdx])
-		if name == procName {
-			pid := strings.TrimSpace(match[pidIdx])
-			pidInt, _ := strconv.Atoi(pid)
-
-			return &ProcessInfo{
-				Status: TrimAnsi(strings.TrimSpace(match[statusIdx])),
-				Uptime: TrimAnsi(strings.TrimSpace(match[uptimeIdx])),
-				PID:    pidInt,
-				CPU:    TrimAnsi(strings.TrimSpace(match[cpuIdx])),
-				Memory: TrimAnsi(strings.TrimSpace(match[memoryIdx])),
-			}, nil
+func GetProcessInfoByName(infos []lid.ServiceInfo, procName string) (*lid.ServiceInfo, error) {
+	for _, info := range infos {
+		if info.Name == procName {
+			return &info, nil
 		}
 	}
 
 	return nil, fmt.Errorf("process %q not found", procName)
 }
 
-func AssertProcessStatus(t *testing.T, processName string, status string) {
-	output := getProcessList(t)
-	processInfo, err := GetProcessInfoByName(output, processName)
+func AssertProcessStatus(t *testing.T, processName string, status lid.ServiceStatus) {
+	processInfo, err := GetProcessInfoByName(getProcessList(t), processName)
 	require.NoError(t, err)
 	assert.Equal(t, status, processInfo.Status)
 }
-func RequireProcessStatus(t *testing.T, processName string, status string) {
-	output := getProcessList(t)
-	processInfo, err := GetProcessInfoByName(output, processName)
+func RequireProcessStatus(t *testing.T, processName string, status lid.ServiceStatus) {
+	processInfo, err := GetProcessInfoByName(getProcessList(t), processName)
 	require.NoError(t, err)
 	require.Equal(t, status, processInfo.Status)
 }
 
 func CaptureRunningProcess(t *testing.T, processName string) *process.Process {
-	output := getProcessList(t)
-	processInfo, err := GetProcessInfoByName(output, processName)
+	processInfo, err := GetProcessInfoByName(getProcessList(t), processName)
 	require.NoError(t, err)
-	require.Equal(t, "Running", processInfo.Status)
-	process, err := process.NewProcess(int32(processInfo.PID))
+	require.Equal(t, lid.RUNNING, processInfo.Status)
+	process, err := process.NewProcess(processInfo.Pid)
 	require.NoError(t, err)
 	isRunning, err := process.IsRunning()
 	require.NoError(t, err)
@@ -126,47 +101,109 @@ func runCmd(t *testing.T, name string, args ...string) string {
 	return outputBuffer.String()
 }
 
-func TestCase1(t *testing.T) {
-	// Build the test application
+// buildCase1 builds the test application and removes it once the test is
+// done, after stopping its services.
+func buildCase1(t *testing.T) {
 	testdataDir := filepath.Join("testdata")
 
 	// Ensure cleanup
-	defer func() {
+	t.Cleanup(func() {
 
 		runCmd(t, "./case1", "stop")
 
 		os.Remove(filepath.Join(testdataDir, "case1"))
 		os.Remove(filepath.Join(testdataDir, "lid.log"))
+		os.RemoveAll(filepath.Join(testdataDir, "logs"))
 		os.Remove(filepath.Join(testdataDir, "go.mod"))
 		os.Remove(filepath.Join(testdataDir, "go.sum"))
-	}()
+	})
 
 	setupGoMod(t, testdataDir)
 	runCmd(t, "go", "mod", "tidy")
 	runCmd(t, "go", "build", "-o", "case1")
+}
 
-	RequireProcessStatus(t, "worker", "Stopped")
+func TestCase1(t *testing.T) {
+	buildCase1(t)
+
+	RequireProcessStatus(t, "worker", lid.STOPPED)
 
 	go runCmd(t, "./case1", "start", "worker")
 
 	time.Sleep(500 * time.Millisecond)
 
-	AssertProcessStatus(t, "worker", "Starting")
-	AssertProcessStatus(t, "unstable-service", "Stopped")
+	AssertProcessStatus(t, "worker", lid.STARTING)
+	AssertProcessStatus(t, "unstable-service", lid.STOPPED)
 
 	// Give services time to start
 	time.Sleep(500 * time.Millisecond)
 	// Test process management
-	AssertProcessStatus(t, "worker", "Running")
-	AssertProcessStatus(t, "unstable-service", "Stopped")
+	AssertProcessStatus(t, "worker", lid.RUNNING)
+	AssertProcessStatus(t, "unstable-service", lid.STOPPED)
 
 	// start other process
 	runCmd(t, "./case1", "start", "unstable-service")
-	AssertProcessStatus(t, "worker", "Running")
-	AssertProcessStatus(t, "unstable-service", "Running")
+	AssertProcessStatus(t, "worker", lid.RUNNING)
+	AssertProcessStatus(t, "unstable-service", lid.RUNNING)
 
 	runCmd(t, "./case1", "stop")
 	time.Sleep(500 * time.Millisecond)
-	RequireProcessStatus(t, "worker", "Stopped")
-	RequireProcessStatus(t, "unstable-service", "Stopped")
+	RequireProcessStatus(t, "worker", lid.STOPPED)
+	RequireProcessStatus(t, "unstable-service", lid.STOPPED)
+}
+
+func TestCase1Daemon(t *testing.T) {
+	buildCase1(t)
+
+	daemon := exec.Command("./case1", "daemon")
+	daemon.Dir = filepath.Join("testdata")
+	daemon.Stderr = os.Stderr
+	daemonOutput, err := daemon.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, daemon.Start())
+
+	// wait for the socket
+	listening := make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(daemonOutput)
+		for scanner.Scan() {
+			fmt.Println(scanner.Text())
+			if strings.Contains(scanner.Text(), "listening") {
+				close(listening)
+				break
+			}
+		}
+		io.Copy(os.Stdout, daemonOutput)
+	}()
+
+	select {
+	case <-listening:
+	case <-time.After(5 * time.Second):
+		daemon.Process.Kill()
+		t.Fatal("Daemon did not come up")
+	}
+
+	runCmd(t, "./case1", "start", "worker")
+	worker := CaptureRunningProcess(t, "worker")
+	ppid, err := worker.Ppid()
+	require.NoError(t, err)
+	assert.Equal(t, int32(daemon.Process.Pid), ppid, "The daemon should own the worker process")
+
+	output := runCmd(t, "./case1", "stop", "worker")
+	assert.Contains(t, output, "worker: Stopped")
+	RequireProcessStatus(t, "worker", lid.STOPPED)
+
+	daemonExited := make(chan error, 1)
+	go func() {
+		daemonExited <- daemon.Wait()
+	}()
+
+	require.NoError(t, daemon.Process.Signal(os.Interrupt))
+	select {
+	case err := <-daemonExited:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		daemon.Process.Kill()
+		t.Fatal("Daemon did not shut down")
+	}
 }
@@ -0,0 +1,73 @@
+package lid_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/robo-monk/lid/lid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func expectLogLines(t *testing.T, lines <-chan string, expected ...string) {
+	t.Helper()
+	for _, message := range expected {
+		select {
+		case line := <-lines:
+			assert.Equal(t, []string{message}, logMessages([]string{line}))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %q", message)
+		}
+	}
+}
+
+func TestLogsFollowRotatedFile(t *testing.T) {
+	t.Parallel()
+	stateDir := t.TempDir()
+	filename := filepath.Join(stateDir, "logs", "backend.log")
+
+	lines := followTestLidLogs(t, stateDir, "logs", "--stderr-only", "backend")
+
+	appendLogRecords(t, filename, lid.LogRecord{Time: time.Now(), Service: "backend", Stream: lid.STREAM_STDERR, Message: "before rotation"})
+	expectLogLines(t, lines, "[backend] before rotation")
+
+	require.NoError(t, os.Rename(filename, filename+"."+time.Now().Format(lid.LOG_ROTATION_TIME_FORMAT)))
+	appendLogRecords(t, filename, lid.LogRecord{Time: time.Now(), Service: "backend", Stream: lid.STREAM_STDERR, Message: "after rotation"})
+	expectLogLines(t, lines, "[backend] after rotation")
+}
+
+func TestLogsFollowTruncatedFile(t *testing.T) {
+	t.Parallel()
+	stateDir := t.TempDir()
+	filename := filepath.Join(stateDir, "logs", "backend.log")
+
+	lines := followTestLidLogs(t, stateDir, "logs", "--stderr-only", "backend")
+
+	appendLogRecords(t, filename, lid.LogRecord{Time: time.Now(), Service: "backend", Stream: lid.STREAM_STDERR, Message: "before truncation"})
+	expectLogLines(t, lines, "[backend] before truncation")
+
+	require.NoError(t, os.Truncate(filename, 0))
+	// like tail, lid can only tell a file was truncated while it is smaller
+	time.Sleep(2 * lid.FOLLOW_POLL_INTERVAL)
+	appendLogRecords(t, filename, lid.LogRecord{Time: time.Now(), Service: "backend", Stream: lid.STREAM_STDERR, Message: "after truncation"})
+	expectLogLines(t, lines, "[backend] after truncation")
+}
+
+// TestLogsFollowWithoutDelay checks that new lines are noticed as they are
+// written rather than on the next poll.
+func TestLogsFollowWithoutDelay(t *testing.T) {
+	t.Parallel()
+	stateDir := t.TempDir()
+	filename := filepath.Join(stateDir, "logs", "backend.log")
+
+	lines := followTestLidLogs(t, stateDir, "logs", "--stderr-only", "backend")
+
+	for range 5 {
+		written := time.Now()
+		appendLogRecords(t, filename, lid.LogRecord{Time: time.Now(), Service: "backend", Stream: lid.STREAM_STDERR, Message: "ping"})
+		expectLogLines(t, lines, "[backend] ping")
+		assert.Less(t, time.Since(written), lid.FOLLOW_POLL_INTERVAL)
+	}
+}
@@ -0,0 +1,86 @@
+package lid_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/robo-monk/lid/lid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func NewTestLid(t *testing.T) *lid.Lid {
+	manager, err := lid.NewWithOptions(lid.LidOptions{
+		LogsFilename: filepath.Join(t.TempDir(), "lid.log"),
+		StateDir:     t.TempDir(),
+	})
+	require.NoError(t, err)
+	return manager
+}
+
+func TestCheckDependencies(t *testing.T) {
+	manager := NewTestLid(t)
+	manager.Register("db", lid.ServiceConfig{Command: []string{"true"}})
+	manager.Register("backend", lid.ServiceConfig{Command: []string{"true"}, DependsOn: []string{"db"}})
+	manager.Register("frontend", lid.ServiceConfig{Command: []string{"true"}, DependsOn: []string{"backend", "db"}})
+
+	assert.NoError(t, manager.CheckDependencies())
+}
+
+func TestCheckDependenciesUnknownService(t *testing.T) {
+	manager := NewTestLid(t)
+	manager.Register("backend", lid.ServiceConfig{Command: []string{"true"}, DependsOn: []string{"db"}})
+
+	err := manager.CheckDependencies()
+	require.Error(t, err)
+	assert.Equal(t, "service 'backend' depends on unknown service 'db'", err.Error())
+}
+
+func TestCheckDependenciesCycle(t *testing.T) {
+	manager := NewTestLid(t)
+	manager.Register("a", lid.ServiceConfig{Command: []string{"true"}, DependsOn: []string{"b"}})
+	manager.Register("b", lid.ServiceConfig{Command: []string{"true"}, DependsOn: []string{"c"}})
+	manager.Register("c", lid.ServiceConfig{Command: []string{"true"}, DependsOn: []string{"a"}})
+
+	err := manager.CheckDependencies()
+	require.Error(t, err)
+	assert.Equal(t, "dependency cycle: a -> b -> c -> a", err.Error())
+}
+
+func TestLegacyStateMigration(t *testing.T) {
+	name := fmt.Sprintf("legacy-%d", os.Getpid())
+	legacy := filepath.Join(os.TempDir(), fmt.Sprintf("service-%s.lid", name))
+	require.NoError(t, lid.ServiceProcess{Status: lid.EXITED, ExitCode: 3}.WriteToFile(legacy))
+	defer os.Remove(legacy)
+
+	stateDir := t.TempDir()
+	manager, err := lid.NewWithOptions(lid.LidOptions{
+		LogsFilename: filepath.Join(t.TempDir(), "lid.log"),
+		StateDir:     stateDir,
+	})
+	require.NoError(t, err)
+	manager.Register(name, lid.ServiceConfig{Command: []string{"true"}})
+
+	assert.NoFileExists(t, legacy)
+	state, err := lid.ReadServiceProcess(filepath.Join(stateDir, fmt.Sprintf("service-%s.lid", name)))
+	require.NoError(t, err)
+	assert.Equal(t, lid.EXITED, state.Status)
+	assert.Equal(t, int32(3), state.ExitCode)
+}
+
+func TestNamespacedStateDir(t *testing.T) {
+	stateHome := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", stateHome)
+
+	for _, namespace := range []string{"project-a", "project-b"} {
+		manager, err := lid.NewWithOptions(lid.LidOptions{
+			LogsFilename: filepath.Join(t.TempDir(), "lid.log"),
+			Namespace:    namespace,
+		})
+		require.NoError(t, err)
+		manager.Register("backend", lid.ServiceConfig{Command: []string{"true"}})
+		assert.DirExists(t, filepath.Join(stateHome, "lid", namespace))
+	}
+}
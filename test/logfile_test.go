@@ -0,0 +1,135 @@
+package lid_test
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robo-monk/lid/lid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readLogFile(t *testing.T, filename string) string {
+	file, err := os.Open(filename)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(filename, ".gz") {
+		gz, err := gzip.NewReader(file)
+		require.NoError(t, err)
+		defer gz.Close()
+		reader = gz
+	}
+
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func logBackups(t *testing.T, filename string) []string {
+	backups, err := filepath.Glob(filename + ".2*")
+	require.NoError(t, err)
+	return backups
+}
+
+func TestLogFileRotatesBySize(t *testing.T) {
+	t.Parallel()
+	filename := filepath.Join(t.TempDir(), "service.log")
+
+	file, err := lid.OpenLogFile(filename, lid.LogRotation{MaxSize: 100, MaxBackups: -1})
+	require.NoError(t, err)
+	defer file.Close()
+
+	var written strings.Builder
+	for i := range 20 {
+		line := fmt.Sprintf("line %02d of the service output\n", i)
+		written.WriteString(line)
+		_, err := file.Write([]byte(line))
+		require.NoError(t, err)
+		// rotated files are named by the millisecond
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	backups := logBackups(t, filename)
+	assert.NotEmpty(t, backups)
+
+	var read strings.Builder
+	for _, backup := range backups {
+		content := readLogFile(t, backup)
+		assert.LessOrEqual(t, len(content), 100)
+		read.WriteString(content)
+	}
+	read.WriteString(readLogFile(t, filename))
+
+	assert.Equal(t, written.String(), read.String(), "No line should be lost or split")
+}
+
+func TestLogFileKeepsMaxBackupsCompressed(t *testing.T) {
+	t.Parallel()
+	filename := filepath.Join(t.TempDir(), "service.log")
+
+	file, err := lid.OpenLogFile(filename, lid.LogRotation{MaxSize: 50, MaxBackups: 2, Compress: true})
+	require.NoError(t, err)
+	defer file.Close()
+
+	for i := range 10 {
+		_, err := fmt.Fprintf(file, "line %02d of the service output\n", i)
+		require.NoError(t, err)
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	backups := logBackups(t, filename)
+	require.Len(t, backups, 2)
+	for _, backup := range backups {
+		assert.True(t, strings.HasSuffix(backup, ".gz"), backup)
+	}
+
+	assert.Equal(t, "line 08 of the service output\n", readLogFile(t, backups[1]))
+	assert.Equal(t, "line 09 of the service output\n", readLogFile(t, filename))
+}
+
+func TestLogFileRotatesByAge(t *testing.T) {
+	t.Parallel()
+	filename := filepath.Join(t.TempDir(), "service.log")
+
+	file, err := lid.OpenLogFile(filename, lid.LogRotation{MaxAge: 50 * time.Millisecond})
+	require.NoError(t, err)
+	defer file.Close()
+
+	fmt.Fprintln(file, "first")
+	fmt.Fprintln(file, "second")
+	assert.Empty(t, logBackups(t, filename))
+
+	time.Sleep(100 * time.Millisecond)
+	fmt.Fprintln(file, "third")
+
+	backups := logBackups(t, filename)
+	require.Len(t, backups, 1)
+	assert.Equal(t, "first\nsecond\n", readLogFile(t, backups[0]))
+	assert.Equal(t, "third\n", readLogFile(t, filename))
+}
+
+func TestServiceOutputInItsOwnLogFile(t *testing.T) {
+	stateDir := t.TempDir()
+	defer killStartedProcesses(t, stateDir)
+
+	_, code := runTestLidOutput(t, stateDir, testLidOptions{}, "start", "backend")
+	require.Equal(t, lid.EXIT_OK, code)
+	_, code = runTestLidOutput(t, stateDir, testLidOptions{}, "stop", "backend")
+	require.Equal(t, lid.EXIT_OK, code)
+
+	serviceLog := readLogFile(t, filepath.Join(stateDir, "logs", "backend.log"))
+	assert.Contains(t, serviceLog, "backend started")
+	assert.NotContains(t, serviceLog, "Stopping service", "Lifecycle messages belong in lid's own log")
+
+	lidLog := readLogFile(t, filepath.Join(stateDir, "lid.log"))
+	assert.Contains(t, lidLog, "[backend] ")
+	assert.Contains(t, lidLog, "Stopping service")
+}
@@ -0,0 +1,136 @@
+package lid_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robo-monk/lid/lid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readLogRecords(t *testing.T, filename string) []lid.LogRecord {
+	var records []lid.LogRecord
+	for _, line := range strings.SplitAfter(readLogFile(t, filename), "\n") {
+		if line == "" {
+			continue
+		}
+
+		record, ok := lid.ParseLogRecord(line)
+		require.True(t, ok, "Expected a JSON record, got %q", line)
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestLogRecordRendering(t *testing.T) {
+	t.Parallel()
+	line := `{"time":"2024-10-17T08:30:00.123Z","msg":"listening on :8080","service":"api","stream":"stdout","pid":4242}` + "\n"
+
+	record, ok := lid.ParseLogRecord(line)
+	require.True(t, ok)
+	assert.Equal(t, "api", record.Service)
+	assert.Equal(t, lid.STREAM_STDOUT, record.Stream)
+	assert.Equal(t, 4242, record.Pid)
+	assert.Equal(t, "listening on :8080", record.Message)
+
+	rendered := record.String()
+	assert.True(t, strings.HasPrefix(rendered, "[api] "), rendered)
+	assert.True(t, strings.HasSuffix(rendered, " listening on :8080\n"), rendered)
+
+	_, ok = lid.ParseLogRecord("[api] 2024/10/17 08:30:00 listening on :8080\n")
+	assert.False(t, ok, "Text lines are not records")
+}
+
+func TestJSONLogFormat(t *testing.T) {
+	stateDir := t.TempDir()
+	defer killStartedProcesses(t, stateDir)
+	jsonLogs := testLidOptions{Env: []string{"LID_TEST_LOG_FORMAT=json"}}
+
+	_, code := runTestLidOutput(t, stateDir, jsonLogs, "start", "backend")
+	require.Equal(t, lid.EXIT_OK, code)
+	pids := startedPids(t, stateDir)
+	require.Len(t, pids, 1)
+	_, code = runTestLidOutput(t, stateDir, jsonLogs, "stop", "backend")
+	require.Equal(t, lid.EXIT_OK, code)
+
+	output := readLogRecords(t, filepath.Join(stateDir, "logs", "backend.log"))
+	require.Len(t, output, 1)
+	assert.Equal(t, "backend started", output[0].Message)
+	assert.Equal(t, "backend", output[0].Service)
+	assert.Equal(t, lid.STREAM_STDOUT, output[0].Stream)
+	assert.Equal(t, int(pids[0]), output[0].Pid)
+	assert.WithinDuration(t, time.Now(), output[0].Time, time.Minute)
+
+	messages := []string{}
+	for _, record := range readLogRecords(t, filepath.Join(stateDir, "lid.log")) {
+		assert.Equal(t, lid.STREAM_LID, record.Stream)
+		assert.NotZero(t, record.Pid)
+		if record.Service == "backend" {
+			messages = append(messages, record.Message)
+		}
+	}
+	assert.Contains(t, messages, "Stopping service")
+}
+
+// followTestLidLogs runs `lid logs` with args in the test lid, logging JSON
+// records, and returns the lines it prints.
+func followTestLidLogs(t *testing.T, stateDir string, args ...string) <-chan string {
+	cmd := testLidCommand(stateDir, testLidOptions{Env: []string{"LID_TEST_LOG_FORMAT=json"}}, args...)
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	// give lid a moment to open the logs before anything is written to them
+	time.Sleep(500 * time.Millisecond)
+	return lines
+}
+
+func appendLogRecords(t *testing.T, filename string, records ...lid.LogRecord) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(filename), 0755))
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for _, record := range records {
+		require.NoError(t, encoder.Encode(record))
+	}
+}
+
+// TestLogsFiltersJSONRecordsByService checks that `lid logs <service>` only
+// shows records of the service, even if others mention it.
+func TestLogsFiltersJSONRecordsByService(t *testing.T) {
+	stateDir := t.TempDir()
+
+	lines := followTestLidLogs(t, stateDir, "logs", "backend")
+	appendLogRecords(t, filepath.Join(stateDir, "lid.log"),
+		lid.LogRecord{Time: time.Now(), Service: "frontend", Stream: lid.STREAM_LID, Message: "waiting for [backend] to start"},
+		lid.LogRecord{Time: time.Now(), Service: "backend", Stream: lid.STREAM_LID, Message: "Started"},
+	)
+
+	select {
+	case line := <-lines:
+		assert.True(t, strings.HasPrefix(line, "[backend] "), line)
+		assert.True(t, strings.HasSuffix(line, " Started"), line)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the logs of backend")
+	}
+}
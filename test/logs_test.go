@@ -0,0 +1,165 @@
+package lid_test
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robo-monk/lid/lid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runTestLidLogs runs `lid logs --no-follow` with args in the test lid and
+// returns the lines it printed.
+func runTestLidLogs(t *testing.T, stateDir, logFormat string, args ...string) []string {
+	options := testLidOptions{Env: []string{"LID_TEST_LOG_FORMAT=" + logFormat}, StdoutOnly: true}
+	output, code := runTestLidOutput(t, stateDir, options, append([]string{"logs", "--no-follow"}, args...)...)
+	require.Equal(t, lid.EXIT_OK, code)
+	return strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+}
+
+// writeLogHistory writes records of backend and frontend a minute apart,
+// starting an hour ago, to lid's log and the logs of the services.
+func writeLogHistory(t *testing.T, stateDir string) time.Time {
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	at := func(minutes int) time.Time {
+		return start.Add(time.Duration(minutes) * time.Minute)
+	}
+
+	appendLogRecords(t, filepath.Join(stateDir, "lid.log"),
+		lid.LogRecord{Time: at(0), Service: "backend", Stream: lid.STREAM_LID, Message: "Starting"},
+		lid.LogRecord{Time: at(3), Service: "frontend", Stream: lid.STREAM_LID, Message: "Starting"},
+		lid.LogRecord{Time: at(5), Service: "backend", Stream: lid.STREAM_LID, Message: "Stopping service"},
+	)
+	appendLogRecords(t, filepath.Join(stateDir, "logs", "backend.log"),
+		lid.LogRecord{Time: at(1), Service: "backend", Stream: lid.STREAM_STDOUT, Message: "listening on :8080"},
+		lid.LogRecord{Time: at(2), Service: "backend", Stream: lid.STREAM_STDERR, Message: "connection timeout"},
+		lid.LogRecord{Time: at(4), Service: "backend", Stream: lid.STREAM_STDOUT, Message: "request timeout"},
+	)
+	return start
+}
+
+func logMessages(lines []string) []string {
+	messages := make([]string, 0, len(lines))
+	for _, line := range lines {
+		line = strings.TrimPrefix(line, "\033[31m")
+		line = strings.TrimSuffix(line, "\033[0m")
+
+		// [service] 2006/01/02 15:04:05 message
+		parts := strings.SplitN(line, " ", 4)
+		if len(parts) == 4 {
+			if _, err := time.Parse(lid.LOG_TIME_FORMAT, parts[1]+" "+parts[2]); err == nil {
+				line = parts[0] + " " + parts[3]
+			}
+		}
+		messages = append(messages, line)
+	}
+	return messages
+}
+
+func TestLogsHistory(t *testing.T) {
+	t.Parallel()
+	stateDir := t.TempDir()
+	writeLogHistory(t, stateDir)
+
+	assert.Equal(t, []string{
+		"[backend] Starting",
+		"[backend] listening on :8080",
+		"[backend] connection timeout",
+		"[backend] request timeout",
+		"[backend] Stopping service",
+	}, logMessages(runTestLidLogs(t, stateDir, "json", "backend")), "Lines of all files should be merged by time")
+
+	assert.Equal(t, []string{
+		"[backend] request timeout",
+		"[backend] Stopping service",
+	}, logMessages(runTestLidLogs(t, stateDir, "json", "-n", "2", "backend")))
+
+	assert.Equal(t, []string{
+		"[backend] connection timeout",
+		"[backend] request timeout",
+	}, logMessages(runTestLidLogs(t, stateDir, "json", "backend", "--grep", "time(out)?$")))
+}
+
+func TestLogsTimeRange(t *testing.T) {
+	t.Parallel()
+	stateDir := t.TempDir()
+	start := writeLogHistory(t, stateDir)
+
+	since := start.Add(2 * time.Minute).Format("2006-01-02 15:04:05")
+	until := start.Add(4 * time.Minute).Format(time.RFC3339)
+
+	assert.Equal(t, []string{
+		"[backend] connection timeout",
+		"[frontend] Starting",
+		"[backend] request timeout",
+	}, logMessages(runTestLidLogs(t, stateDir, "json", "--since", since, "--until", until)))
+
+	assert.Equal(t, []string{""}, runTestLidLogs(t, stateDir, "json", "--since", "10m"), "Nothing was written in the last 10 minutes")
+}
+
+func TestLogsTextHistoryAcrossRotation(t *testing.T) {
+	t.Parallel()
+	stateDir := t.TempDir()
+	logsDir := filepath.Join(stateDir, "logs")
+	require.NoError(t, os.MkdirAll(logsDir, 0755))
+
+	start := time.Now().Add(-time.Hour)
+	line := func(minutes int, message string) string {
+		return fmt.Sprintf("%s %s\n", start.Add(time.Duration(minutes)*time.Minute).Format(lid.LOG_TIME_FORMAT), message)
+	}
+
+	backup, err := os.Create(filepath.Join(logsDir, "backend.log."+start.Format(lid.LOG_ROTATION_TIME_FORMAT)+".gz"))
+	require.NoError(t, err)
+	gz := gzip.NewWriter(backup)
+	fmt.Fprint(gz, line(0, "rotated away"))
+	require.NoError(t, gz.Close())
+	require.NoError(t, backup.Close())
+
+	require.NoError(t, os.WriteFile(filepath.Join(logsDir, "backend.log"), []byte(line(2, "panic: oh no")+"goroutine 1 [running]:\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(stateDir, "lid.log"), []byte("[backend] "+line(1, "Started with PID: 42")), 0644))
+
+	assert.Equal(t, []string{
+		"[backend] rotated away",
+		"[backend] Started with PID: 42",
+		"[backend] panic: oh no",
+		"[backend] goroutine 1 [running]:",
+	}, logMessages(runTestLidLogs(t, stateDir, "text", "backend")))
+
+	assert.Equal(t, []string{
+		"[backend] panic: oh no",
+		"[backend] goroutine 1 [running]:",
+	}, logMessages(runTestLidLogs(t, stateDir, "text", "backend", "--since", "59m")), "Lines without a time belong to the line before them")
+}
+
+func TestLogsShowsHistoryBeforeFollowing(t *testing.T) {
+	t.Parallel()
+	stateDir := t.TempDir()
+	writeLogHistory(t, stateDir)
+
+	lines := followTestLidLogs(t, stateDir, "logs", "-n", "1", "--stderr-only", "backend")
+	appendLogRecords(t, filepath.Join(stateDir, "logs", "backend.log"),
+		lid.LogRecord{Time: time.Now(), Service: "backend", Stream: lid.STREAM_STDERR, Message: "connection reset"},
+	)
+
+	for _, expected := range []string{"[backend] connection timeout", "[backend] connection reset"} {
+		select {
+		case line := <-lines:
+			assert.Equal(t, []string{expected}, logMessages([]string{line}))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %q", expected)
+		}
+	}
+}
+
+func TestLogsUnknownService(t *testing.T) {
+	t.Parallel()
+	output, code := runTestLidOutput(t, t.TempDir(), testLidOptions{}, "logs", "--no-follow", "nope")
+	assert.Equal(t, lid.EXIT_USAGE, code)
+	assert.Contains(t, output, "Service 'nope' not found")
+}
@@ -0,0 +1,129 @@
+package lid_test
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/robo-monk/lid/lid"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain lets the test binary double as a lid executable, so tests can run
+// lid commands from several processes at once. See runTestLid.
+func TestMain(m *testing.M) {
+	if stateDir := os.Getenv("LID_TEST_STATE_DIR"); stateDir != "" {
+		runTestLid(stateDir)
+		return
+	}
+
+	os.Exit(m.Run())
+}
+
+// runTestLid runs the lid command in os.Args with a "backend" service that
+// appends the PID of every process it starts to the file "pids", and services
+// failing to start in several ways, next to the services of lid.yaml in
+// stateDir if a test wrote one. It logs JSON records if LID_TEST_LOG_FORMAT is
+// "json", and only registers the services of lid.yaml if LID_TEST_CONFIG_ONLY
+// is set.
+func runTestLid(stateDir string) {
+	logFormat := lid.LogFormatText
+	if os.Getenv("LID_TEST_LOG_FORMAT") == "json" {
+		logFormat = lid.LogFormatJSON
+	}
+
+	manager, err := lid.NewWithOptions(lid.LidOptions{
+		LogsFilename: filepath.Join(stateDir, "lid.log"),
+		StateDir:     stateDir,
+		LogFormat:    logFormat,
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if os.Getenv("LID_TEST_CONFIG_ONLY") == "" {
+		registerTestServices(manager, stateDir)
+	}
+
+	config, err := lid.LoadConfig(filepath.Join(stateDir, "lid.yaml"))
+	if err == nil {
+		// Run reports the services it rejects
+		manager.RegisterConfig(config)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalln(err)
+	}
+
+	manager.Run()
+}
+
+// testLidOptions tells runTestLidOutput how to run the test lid.
+type testLidOptions struct {
+	Env        []string // added to its environment, e.g. LID_TEST_CONFIG_ONLY=1
+	StdoutOnly bool     // leave what lid prints to stderr out of the output
+}
+
+// testLidCommand returns a command of the test lid running on stateDir.
+func testLidCommand(stateDir string, options testLidOptions, args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(append(os.Environ(), "LID_TEST_STATE_DIR="+stateDir), options.Env...)
+	return cmd
+}
+
+// runTestLidOutput runs a command of the test lid and returns its output and
+// exit code.
+func runTestLidOutput(t *testing.T, stateDir string, options testLidOptions, args ...string) (string, int) {
+	cmd := testLidCommand(stateDir, options, args...)
+
+	var output []byte
+	var err error
+	if options.StdoutOnly {
+		cmd.Stderr = os.Stderr
+		output, err = cmd.Output()
+	} else {
+		output, err = cmd.CombinedOutput()
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return string(output), exitErr.ExitCode()
+	}
+	require.NoError(t, err)
+	return string(output), 0
+}
+
+func registerTestServices(manager *lid.Lid, stateDir string) {
+	manager.Register("backend", lid.ServiceConfig{
+		Command: []string{"bash", "-c", `echo $$ >> "$0"; echo "backend started"; exec sleep 30`, filepath.Join(stateDir, "pids")},
+		StdoutReadinessCheck: func(line string) bool {
+			return line == "backend started"
+		},
+	})
+
+	// prints what lid used to look for, but never passes its own check
+	manager.Register("impostor", lid.ServiceConfig{
+		Command:               []string{"bash", "-c", `echo "Readiness check passed"; exec sleep 30`},
+		ReadinessCheckTimeout: 500 * time.Millisecond,
+		StdoutReadinessCheck: func(line string) bool {
+			return line == "impostor ready"
+		},
+	})
+
+	manager.Register("crasher", lid.ServiceConfig{
+		Command: []string{"bash", "-c", `echo "boom" >&2; exit 3`},
+		StdoutReadinessCheck: func(line string) bool {
+			return line == "crasher ready"
+		},
+	})
+
+	manager.Register("rejected", lid.ServiceConfig{
+		Command: []string{"true"},
+		OnBeforeStart: func(self *lid.Service) error {
+			return errors.New("maintenance window")
+		},
+	})
+}
@@ -0,0 +1,121 @@
+package lid_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robo-monk/lid/lid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runTestLidJSON runs a lid command with --json and returns the results it
+// printed and its exit code.
+func runTestLidJSON(t *testing.T, stateDir string, args ...string) ([]lid.ServiceResult, int) {
+	output, code := runTestLidOutput(t, stateDir, testLidOptions{StdoutOnly: true}, append(args, "--json")...)
+
+	var results []lid.ServiceResult
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		var result lid.ServiceResult
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &result), "stdout should only hold results: %s", output)
+		results = append(results, result)
+	}
+	return results, code
+}
+
+func TestServiceResultJSON(t *testing.T) {
+	result := lid.ServiceResult{
+		Name:     "api",
+		Action:   "start",
+		Outcome:  lid.OUTCOME_FAILED,
+		Duration: 1500 * time.Millisecond,
+		Error:    "readiness check timed out",
+	}
+
+	data, err := json.Marshal(result)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name":"api","action":"start","outcome":"failed","duration":1.5,"error":"readiness check timed out"}`, string(data))
+
+	var parsed lid.ServiceResult
+	require.NoError(t, json.Unmarshal(data, &parsed))
+	assert.Equal(t, result, parsed)
+}
+
+func TestExitCode(t *testing.T) {
+	started := lid.ServiceResult{Outcome: lid.OUTCOME_STARTED}
+	failed := lid.ServiceResult{Outcome: lid.OUTCOME_FAILED}
+	locked := lid.ServiceResult{Outcome: lid.OUTCOME_LOCKED}
+	down := lid.ServiceResult{Outcome: lid.OUTCOME_NOT_RUNNING}
+	namedDown := lid.ServiceResult{Outcome: lid.OUTCOME_NOT_RUNNING, Explicit: true}
+
+	assert.Equal(t, lid.EXIT_OK, lid.ExitCode(nil))
+	assert.Equal(t, lid.EXIT_OK, lid.ExitCode([]lid.ServiceResult{started, down}))
+	assert.Equal(t, lid.EXIT_NOT_RUNNING, lid.ExitCode([]lid.ServiceResult{started, namedDown}))
+	assert.Equal(t, lid.EXIT_LOCKED, lid.ExitCode([]lid.ServiceResult{namedDown, locked}))
+	assert.Equal(t, lid.EXIT_FAILURE, lid.ExitCode([]lid.ServiceResult{locked, failed, namedDown}))
+}
+
+func TestCommandResults(t *testing.T) {
+	t.Parallel()
+	stateDir := t.TempDir()
+	defer killStartedProcesses(t, stateDir)
+
+	results, code := runTestLidJSON(t, stateDir, "start", "backend")
+	assert.Equal(t, lid.EXIT_OK, code)
+	require.Len(t, results, 1)
+	assert.Equal(t, "backend", results[0].Name)
+	assert.Equal(t, "start", results[0].Action)
+	assert.Equal(t, lid.OUTCOME_STARTED, results[0].Outcome)
+	assert.Equal(t, startedPids(t, stateDir), []int32{results[0].Pid})
+	assert.Positive(t, results[0].Duration)
+
+	results, code = runTestLidJSON(t, stateDir, "start", "backend")
+	assert.Equal(t, lid.EXIT_OK, code)
+	require.Len(t, results, 1)
+	assert.Equal(t, lid.OUTCOME_ALREADY_RUNNING, results[0].Outcome)
+
+	results, code = runTestLidJSON(t, stateDir, "stop", "backend")
+	assert.Equal(t, lid.EXIT_OK, code)
+	require.Len(t, results, 1)
+	assert.Equal(t, "stop", results[0].Action)
+	assert.Equal(t, lid.OUTCOME_STOPPED, results[0].Outcome)
+	assert.Equal(t, startedPids(t, stateDir), []int32{results[0].Pid})
+
+	results, code = runTestLidJSON(t, stateDir, "stop", "backend")
+	assert.Equal(t, lid.EXIT_NOT_RUNNING, code, "Stopping a service by name that is not running should be reported")
+	require.Len(t, results, 1)
+	assert.Equal(t, lid.OUTCOME_NOT_RUNNING, results[0].Outcome)
+
+	_, code = runTestLidJSON(t, stateDir, "stop")
+	assert.Equal(t, lid.EXIT_OK, code, "Stopping all services should succeed if they are down")
+}
+
+func TestCommandResultsOfFailures(t *testing.T) {
+	t.Parallel()
+	stateDir := t.TempDir()
+
+	results, code := runTestLidJSON(t, stateDir, "start", "crasher")
+	waitForSupervisor(t, stateDir, "crasher")
+
+	assert.Equal(t, lid.EXIT_FAILURE, code)
+	require.Len(t, results, 1)
+	assert.Equal(t, lid.OUTCOME_FAILED, results[0].Outcome)
+	assert.Contains(t, results[0].Error, "exited with code 3 before becoming ready")
+
+	output, code := runTestLidOutput(t, stateDir, testLidOptions{StdoutOnly: true}, "list", "crasher", "--format", "{{.Status}} {{.ExitCode}}")
+	assert.Equal(t, lid.EXIT_NOT_RUNNING, code, "A service that exited should be reported")
+	assert.Equal(t, "Exited 3\n", output)
+
+	_, code = runTestLidOutput(t, stateDir, testLidOptions{}, "start", "nonexistent")
+	assert.Equal(t, lid.EXIT_USAGE, code)
+
+	_, code = runTestLidOutput(t, stateDir, testLidOptions{}, "stop", "--frobnicate")
+	assert.Equal(t, lid.EXIT_USAGE, code)
+
+	_, code = runTestLidOutput(t, stateDir, testLidOptions{}, "frobnicate")
+	assert.Equal(t, lid.EXIT_USAGE, code)
+}
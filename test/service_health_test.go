@@ -0,0 +1,196 @@
+package lid_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/robo-monk/lid/lid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readState(t *testing.T, s *lid.Service) lid.ServiceProcess {
+	state, err := lid.ReadServiceProcess(s.GetServiceProcessFilename())
+	require.NoError(t, err)
+	return state
+}
+
+func waitForHealth(t *testing.T, s *lid.Service, health lid.HealthStatus, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		// the service might not have written its state yet
+		state, err := lid.ReadServiceProcess(s.GetServiceProcessFilename())
+		if err == nil && state.Health == health {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for health %s, got %s", health, readState(t, s).Health)
+}
+
+func TestHTTPHealthCheck(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	ts, s := NewTestService(t, lid.ServiceConfig{
+		Command: []string{"bash", "./e2e/mock_services/long_running.sh"},
+		HealthCheck: &lid.HealthCheck{
+			HTTP:      &lid.HTTPProbe{URL: server.URL, ExpectedBody: "ok"},
+			Interval:  20 * time.Millisecond,
+			Retries:   2,
+			Readiness: true,
+		},
+	})
+
+	go ts.Start()
+	waitForStatus(t, s, lid.RUNNING, 1*time.Second)
+	assert.Equal(t, lid.HEALTHY, readState(t, s).Health)
+
+	healthy.Store(false)
+	waitForHealth(t, s, lid.UNHEALTHY, 1*time.Second)
+	assert.GreaterOrEqual(t, readState(t, s).HealthFailures, int32(2))
+
+	healthy.Store(true)
+	waitForHealth(t, s, lid.HEALTHY, 1*time.Second)
+	assert.Equal(t, int32(0), readState(t, s).HealthFailures)
+
+	s.Stop()
+	ts.WaitOrTimeout(1 * time.Second)
+}
+
+func TestTCPHealthCheckGatesReadiness(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	ts, s := NewTestService(t, lid.ServiceConfig{
+		Command: []string{"bash", "./e2e/mock_services/long_running.sh"},
+		HealthCheck: &lid.HealthCheck{
+			TCP:       address,
+			Interval:  20 * time.Millisecond,
+			Readiness: true,
+		},
+	})
+
+	go ts.Start()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, lid.STARTING, s.GetCachedStatus(), "Service should not be ready while nothing listens")
+
+	listener, err = net.Listen("tcp", address)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	waitForStatus(t, s, lid.RUNNING, 1*time.Second)
+
+	s.Stop()
+	ts.WaitOrTimeout(1 * time.Second)
+}
+
+func TestExecHealthCheck(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "healthy")
+	require.NoError(t, os.WriteFile(marker, nil, 0644))
+
+	ts, s := NewTestService(t, lid.ServiceConfig{
+		Command: []string{"bash", "./e2e/mock_services/long_running.sh"},
+		HealthCheck: &lid.HealthCheck{
+			Exec:     []string{"test", "-e", marker},
+			Interval: 20 * time.Millisecond,
+			Retries:  1,
+		},
+	})
+
+	go ts.Start()
+	waitForHealth(t, s, lid.HEALTHY, 1*time.Second)
+
+	require.NoError(t, os.Remove(marker))
+	waitForHealth(t, s, lid.UNHEALTHY, 1*time.Second)
+
+	s.Stop()
+	ts.WaitOrTimeout(1 * time.Second)
+}
+
+func TestLivenessRestart(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	ts, s := NewTestService(t, lid.ServiceConfig{
+		Command: []string{"bash", "./e2e/mock_services/long_running.sh"},
+		HealthCheck: &lid.HealthCheck{
+			HTTP:      &lid.HTTPProbe{URL: server.URL},
+			Interval:  20 * time.Millisecond,
+			Retries:   2,
+			OnFailure: lid.LivenessRestart,
+		},
+	})
+
+	go ts.Start()
+	waitForStatus(t, s, lid.RUNNING, 1*time.Second)
+	oldPid := s.GetPid()
+
+	healthy.Store(false)
+	deadline := time.Now().Add(2 * time.Second)
+	for readState(t, s).Restarts == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	healthy.Store(true)
+
+	waitForStatus(t, s, lid.RUNNING, 1*time.Second)
+	state := readState(t, s)
+	assert.NotEqual(t, oldPid, state.Pid, "Service should have been restarted")
+	assert.GreaterOrEqual(t, state.Restarts, int32(1))
+	assert.GreaterOrEqual(t, state.LivenessActions, int32(1))
+
+	s.Stop()
+	ts.WaitOrTimeout(1 * time.Second)
+}
+
+func TestLivenessHook(t *testing.T) {
+	var hookCalls atomic.Int32
+
+	ts, s := NewTestService(t, lid.ServiceConfig{
+		Command: []string{"bash", "./e2e/mock_services/long_running.sh"},
+		HealthCheck: &lid.HealthCheck{
+			Exec:      []string{"false"},
+			Interval:  20 * time.Millisecond,
+			Retries:   1,
+			OnFailure: lid.LivenessHook,
+			OnFailureHook: func(self *lid.Service) {
+				hookCalls.Add(1)
+			},
+		},
+	})
+
+	go ts.Start()
+	waitForHealth(t, s, lid.UNHEALTHY, 1*time.Second)
+	time.Sleep(100 * time.Millisecond)
+
+	assert.GreaterOrEqual(t, hookCalls.Load(), int32(2), "Hook should run on every failure")
+	assert.GreaterOrEqual(t, readState(t, s).LivenessActions, hookCalls.Load()-1)
+	assert.True(t, s.IsRunning(), "Hook action should not stop the service")
+
+	s.Stop()
+	ts.WaitOrTimeout(1 * time.Second)
+}
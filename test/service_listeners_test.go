@@ -0,0 +1,59 @@
+package lid_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robo-monk/lid/lid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenersArePassed(t *testing.T) {
+	var mu sync.Mutex
+	var lines []string
+
+	ts, s := NewTestService(t, lid.ServiceConfig{
+		Command:   []string{"bash", "-c", `echo "fds=$LISTEN_FDS pidmatch=$([ "$LISTEN_PID" = "$$" ] && echo yes) socket=$(readlink /proc/$$/fd/3)"; sleep 10`},
+		Listeners: []string{"tcp://127.0.0.1:0"},
+		StdoutReadinessCheck: func(line string) bool {
+			if !strings.HasPrefix(line, "fds=") {
+				return false
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			lines = append(lines, line)
+			return true
+		},
+	})
+
+	go ts.Start()
+	waitForStatus(t, s, lid.RUNNING, 1*time.Second)
+
+	require.NoError(t, s.Reload())
+
+	mu.Lock()
+	require.Len(t, lines, 2)
+	for _, line := range lines {
+		assert.Contains(t, line, "fds=1 pidmatch=yes socket=socket:[")
+	}
+	assert.Equal(t, lines[0], lines[1], fmt.Sprintf("Both processes should get the same socket: %v", lines))
+	mu.Unlock()
+
+	s.Stop()
+	ts.WaitOrTimeout(1 * time.Second)
+}
+
+func TestInvalidListener(t *testing.T) {
+	_, s := NewTestService(t, lid.ServiceConfig{
+		Command:   []string{"bash", "-c", "sleep 1"},
+		Listeners: []string{"udp://:1234"},
+	})
+
+	assert.Error(t, s.Start())
+	assert.False(t, s.IsRunning())
+}
@@ -0,0 +1,153 @@
+package lid_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/robo-monk/lid/lid"
+	"github.com/shirou/gopsutil/v4/process"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// assertStopped asserts that `lid stop` succeeded, or found the service
+// stopped by another process already.
+func assertStopped(t *testing.T, output string, code int) {
+	assert.Contains(t, []int{lid.EXIT_OK, lid.EXIT_NOT_RUNNING}, code, output)
+}
+
+// startedPids returns the PIDs of all processes the test lid started.
+func startedPids(t *testing.T, stateDir string) []int32 {
+	data, err := os.ReadFile(filepath.Join(stateDir, "pids"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	require.NoError(t, err)
+
+	var pids []int32
+	for _, line := range strings.Fields(string(data)) {
+		pid, err := strconv.Atoi(line)
+		require.NoError(t, err)
+		pids = append(pids, int32(pid))
+	}
+	return pids
+}
+
+func runningPids(t *testing.T, stateDir string) []int32 {
+	var running []int32
+	for _, pid := range startedPids(t, stateDir) {
+		if proc, err := process.NewProcess(pid); err == nil {
+			if ok, _ := proc.IsRunning(); ok {
+				running = append(running, pid)
+			}
+		}
+	}
+	return running
+}
+
+func killStartedProcesses(t *testing.T, stateDir string) {
+	for _, pid := range runningPids(t, stateDir) {
+		syscall.Kill(int(pid), syscall.SIGKILL)
+	}
+}
+
+func TestConcurrentStartFromSeveralProcesses(t *testing.T) {
+	stateDir := t.TempDir()
+	defer killStartedProcesses(t, stateDir)
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			output, code := runTestLidOutput(t, stateDir, testLidOptions{}, "start", "backend")
+			assert.Equal(t, lid.EXIT_OK, code, output)
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, startedPids(t, stateDir), 1, "The service should have been started exactly once")
+
+	state, err := lid.ReadServiceProcess(filepath.Join(stateDir, "service-backend.lid"))
+	require.NoError(t, err)
+	assert.Equal(t, lid.RUNNING, state.Status)
+	assert.Equal(t, startedPids(t, stateDir), []int32{state.Pid})
+
+	_, code := runTestLidOutput(t, stateDir, testLidOptions{}, "stop", "backend")
+	require.Equal(t, lid.EXIT_OK, code)
+	assert.Empty(t, runningPids(t, stateDir))
+}
+
+func TestConcurrentStartStopFromSeveralProcesses(t *testing.T) {
+	stateDir := t.TempDir()
+	defer killStartedProcesses(t, stateDir)
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 1 {
+				output, code := runTestLidOutput(t, stateDir, testLidOptions{}, "stop", "backend")
+				assertStopped(t, output, code)
+			} else {
+				output, code := runTestLidOutput(t, stateDir, testLidOptions{}, "start", "backend")
+				assert.Equal(t, lid.EXIT_OK, code, output)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// whichever command ran last, the state has to match the processes
+	state, err := lid.ReadServiceProcess(filepath.Join(stateDir, "service-backend.lid"))
+	require.NoError(t, err)
+
+	// a stopped process might take a moment to go away
+	deadline := time.Now().Add(2 * time.Second)
+	running := runningPids(t, stateDir)
+	for len(running) > 1 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		running = runningPids(t, stateDir)
+	}
+
+	switch state.Status {
+	case lid.RUNNING:
+		assert.Equal(t, []int32{state.Pid}, running)
+	case lid.STOPPED:
+		assert.Empty(t, running)
+	default:
+		t.Fatalf("Unexpected status %s", state.Status)
+	}
+
+	output, code := runTestLidOutput(t, stateDir, testLidOptions{}, "stop", "backend")
+	assertStopped(t, output, code)
+}
+
+func TestStartWhileSupervisedElsewhere(t *testing.T) {
+	stateDir := t.TempDir()
+	config := lid.ServiceConfig{
+		Command:            []string{"false"},
+		StateDir:           stateDir,
+		RestartPolicy:      lid.RestartAlways,
+		RestartDelay:       1 * time.Second,
+		CrashLoopThreshold: -1,
+	}
+
+	ts, s := NewTestService(t, config)
+	go ts.Start()
+	waitForStatus(t, s, lid.BACKOFF, 1*time.Second)
+
+	// another supervisor of the same service, as a second lid process would be
+	other := lid.NewService(t.Name(), config)
+	err := other.Start()
+	assert.ErrorIs(t, err, lid.ErrServiceLocked)
+
+	s.Stop()
+	ts.WaitOrTimeout(2 * time.Second)
+}
@@ -0,0 +1,113 @@
+package lid_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robo-monk/lid/lid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// outputBuffer collects the output of a service while it is written.
+type outputBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *outputBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestStdoutAndStderrAreReadConcurrently checks that stderr reaches its
+// destination while the process still runs and keeps its stdout open.
+func TestStdoutAndStderrAreReadConcurrently(t *testing.T) {
+	stdout, stderr := &outputBuffer{}, &outputBuffer{}
+	ts, s := NewTestService(t, lid.ServiceConfig{
+		Command: []string{"bash", "-c", "echo oops >&2; echo ready; exec sleep 30"},
+		Stdout:  stdout,
+		Stderr:  stderr,
+	})
+
+	go ts.Start()
+	defer func() {
+		require.NoError(t, s.Stop())
+		ts.WaitOrTimeout(5 * time.Second)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return stderr.String() == "oops\n"
+	}, 2*time.Second, 10*time.Millisecond, "stderr should arrive while the process runs")
+	assert.Equal(t, "ready\n", stdout.String())
+}
+
+func TestLogsStderrOnly(t *testing.T) {
+	stateDir := t.TempDir()
+
+	lines := followTestLidLogs(t, stateDir, "logs", "backend", "--stderr-only")
+	appendLogRecords(t, filepath.Join(stateDir, "logs", "backend.log"),
+		lid.LogRecord{Time: time.Now(), Service: "backend", Stream: lid.STREAM_STDOUT, Message: "listening"},
+		lid.LogRecord{Time: time.Now(), Service: "backend", Stream: lid.STREAM_STDERR, Message: "connection refused"},
+	)
+
+	select {
+	case line := <-lines:
+		assert.Contains(t, line, "[backend] ")
+		assert.Contains(t, line, " connection refused")
+		assert.True(t, strings.HasPrefix(line, "\033[31m"), "stderr should be highlighted: %q", line)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the stderr of backend")
+	}
+}
+
+func TestLogsStderrOnlyText(t *testing.T) {
+	stateDir := t.TempDir()
+	defer killStartedProcesses(t, stateDir)
+
+	require.NoError(t, os.WriteFile(filepath.Join(stateDir, "lid.yaml"), []byte(`
+services:
+  worker:
+    command: [bash, -c, 'echo $$ >> pids; echo "connection refused" >&2; echo "worker ready"; exec sleep 30']
+    readiness_pattern: 'worker ready'
+  shared:
+    command: ['true']
+    log_file: shared.log
+    err_log_file: shared.log
+`), 0644))
+
+	output, code := runTestLidOutput(t, stateDir, testLidOptions{}, "start", "worker")
+	require.Equal(t, lid.EXIT_OK, code, output)
+	assert.FileExists(t, filepath.Join(stateDir, "logs", "worker.err.log"), "stderr should have a file of its own")
+
+	var lines []string
+	assert.Eventually(t, func() bool {
+		output, code := runTestLidOutput(t, stateDir, testLidOptions{StdoutOnly: true}, "logs", "--no-follow", "--stderr-only", "worker")
+		require.Equal(t, lid.EXIT_OK, code)
+		lines = strings.Split(strings.TrimSpace(output), "\n")
+		return output != ""
+	}, 2*time.Second, 50*time.Millisecond)
+	require.Len(t, lines, 1)
+	assert.Contains(t, lines[0], "[worker] ")
+	assert.Contains(t, lines[0], " connection refused")
+	assert.True(t, strings.HasPrefix(lines[0], "\033[31m"), "stderr should be highlighted: %q", lines[0])
+
+	output, code = runTestLidOutput(t, stateDir, testLidOptions{}, "logs", "--no-follow", "--stderr-only", "shared")
+	assert.Equal(t, lid.EXIT_USAGE, code)
+	assert.Contains(t, output, "its ErrLogFile is its LogFile")
+
+	_, code = runTestLidOutput(t, stateDir, testLidOptions{}, "stop", "worker")
+	assert.Equal(t, lid.EXIT_OK, code)
+}
@@ -1,11 +1,17 @@
 package lid_test
 
 import (
+	"encoding/binary"
+	"os"
+	"os/exec"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/robo-monk/lid/lid"
+	"github.com/shirou/gopsutil/v4/process"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestServiceProcessReadWrite(t *testing.T) {
@@ -32,6 +38,39 @@ func TestServiceProcessReadWrite(t *testing.T) {
 	}
 }
 
+// TestRunningProcessIdentity checks that a PID is only trusted as long as its
+// process started when the state says it did.
+func TestRunningProcessIdentity(t *testing.T) {
+	t.Parallel()
+	_, s := NewTestService(t, lid.ServiceConfig{Command: []string{"true"}})
+
+	proc, err := process.NewProcess(int32(os.Getpid()))
+	require.NoError(t, err)
+	startTime, err := proc.CreateTime()
+	require.NoError(t, err)
+
+	// the state of a process that is still the one lid started
+	require.NoError(t, s.WriteServiceProcess(lid.ServiceProcess{
+		Status:    lid.RUNNING,
+		Pid:       int32(os.Getpid()),
+		StartTime: startTime,
+	}))
+	_, err = s.GetRunningProcess()
+	assert.NoError(t, err)
+	assert.True(t, s.IsRunning())
+
+	// the PID was reused by a process that started later
+	require.NoError(t, s.WriteServiceProcess(lid.ServiceProcess{
+		Status:    lid.RUNNING,
+		Pid:       int32(os.Getpid()),
+		StartTime: startTime - 60_000,
+	}))
+	_, err = s.GetRunningProcess()
+	assert.ErrorIs(t, err, lid.ErrProcessStale)
+	assert.False(t, s.IsRunning())
+	assert.Error(t, s.Stop(), "Stop should not signal a process lid did not start")
+}
+
 // TestReadServiceProcessFileNotFound checks behavior when file doesn't exist.
 func TestReadServiceProcessFileNotFound(t *testing.T) {
 	t.Parallel()
@@ -42,18 +81,90 @@ func TestReadServiceProcessFileNotFound(t *testing.T) {
 	assert.NotNil(t, err, "Expected error not to be nil")
 }
 
-// func TestReadServiceProcessCorruptFile(t *testing.T) {
-// 	t.Parallel()
-// 	tmpDir := t.TempDir()
-// 	filename := filepath.Join(tmpDir, "corrupt.lid")
+func TestReadServiceProcessCorruptFile(t *testing.T) {
+	t.Parallel()
+	tmpDir := t.TempDir()
+
+	for name, content := range map[string]string{
+		"garbage":   "not a valid struct",
+		"empty":     "",
+		"truncated": `{"version":1,"status":"runn`,
+		"version":   `{"version":99,"status":"running"}`,
+	} {
+		filename := filepath.Join(tmpDir, name+".lid")
+		require.NoError(t, os.WriteFile(filename, []byte(content), 0644))
+
+		_, err := lid.ReadServiceProcess(filename)
+		assert.ErrorIs(t, err, lid.ErrProcessCorrupt, name)
+	}
+}
+
+func TestReadLegacyServiceProcess(t *testing.T) {
+	t.Parallel()
+	filename := filepath.Join(t.TempDir(), "legacy.lid")
+
+	// {Status: RUNNING, Pid: 1234} as written by binary.Write
+	require.NoError(t, os.WriteFile(filename, []byte{3, 0xd2, 0x04, 0, 0}, 0644))
+
+	sp, err := lid.ReadServiceProcess(filename)
+	require.NoError(t, err)
+	assert.Equal(t, lid.RUNNING, sp.Status)
+	assert.Equal(t, int32(1234), sp.Pid)
+}
+
+func TestServiceProcessFileFormat(t *testing.T) {
+	t.Parallel()
+	filename := filepath.Join(t.TempDir(), "service-process.lid")
+
+	original := lid.ServiceProcess{
+		Status:    lid.EXITED,
+		Pid:       1234,
+		StartTime: 1700000000000,
+		Restarts:  2,
+		ExitCode:  3,
+		Health:    lid.UNHEALTHY,
+	}
+	require.NoError(t, original.WriteToFile(filename))
 
-// 	if err := os.WriteFile(filename, []byte("not a valid struct"), 0644); err != nil {
-// 		t.Fatal(err)
-// 	}
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"version":1`)
+	assert.Contains(t, string(data), `"status":"exited"`)
+	assert.Contains(t, string(data), `"health":"unhealthy"`)
 
-// 	sp, err := lid.ReadServiceProcess(filename)
+	readBack, err := lid.ReadServiceProcess(filename)
+	require.NoError(t, err)
+	assert.Equal(t, original, readBack)
 
-// 	assert.Equal(t, int64(0), sp.Pid, "Expect PID to be 0 (inactive)")
-// 	assert.Equal(t, lid.STOPPED, sp.Status, "Expected Process status to be STOPPED")
-// 	assert.Nil(t, err, "Expected error to be nil")
-// }
+	entries, err := os.ReadDir(filepath.Dir(filename))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "No temporary files should be left behind")
+}
+
+func TestLegacyPidIsNotTrusted(t *testing.T) {
+	// stands in for an unrelated program that reused the PID after a reboot
+	foreign := exec.Command("sleep", "30")
+	require.NoError(t, foreign.Start())
+	defer foreign.Process.Kill()
+	exited := make(chan struct{})
+	go func() {
+		foreign.Wait()
+		close(exited)
+	}()
+
+	_, s := NewTestService(t, lid.ServiceConfig{Command: []string{"true"}})
+	legacy := []byte{byte(lid.RUNNING), 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(legacy[1:], uint32(foreign.Process.Pid))
+	require.NoError(t, os.WriteFile(s.GetServiceProcessFilename(), legacy, 0644))
+
+	_, err := s.GetRunningProcess()
+	assert.ErrorIs(t, err, lid.ErrProcessStale)
+	assert.False(t, s.IsRunning())
+
+	s.Stop()
+	select {
+	case <-exited:
+		t.Fatal("The foreign process should not have been signaled")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
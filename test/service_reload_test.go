@@ -0,0 +1,156 @@
+package lid_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robo-monk/lid/lid"
+	"github.com/shirou/gopsutil/v4/process"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func waitForStatus(t *testing.T, s *lid.Service, status lid.ServiceStatus, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if s.GetCachedStatus() == status {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for status %s, got %s", status, s.GetCachedStatus())
+}
+
+func TestReload(t *testing.T) {
+	ts, s := NewTestService(t, lid.ServiceConfig{
+		Command: []string{"bash", "./e2e/mock_services/long_running.sh"},
+		StdoutReadinessCheck: func(line string) bool {
+			return strings.Contains(line, "Service is running")
+		},
+	})
+
+	go ts.Start()
+	waitForStatus(t, s, lid.RUNNING, 1*time.Second)
+	oldPid := s.GetPid()
+
+	require.NoError(t, s.Reload())
+
+	newPid := s.GetPid()
+	assert.NotEqual(t, oldPid, newPid, "PID should have changed")
+	assert.Equal(t, lid.RUNNING, s.GetCachedStatus())
+
+	oldProc, err := process.NewProcess(oldPid)
+	if err == nil {
+		running, _ := oldProc.IsRunning()
+		assert.False(t, running, "Old process should have been stopped")
+	}
+
+	s.Stop()
+	ts.WaitOrTimeout(1 * time.Second)
+	assert.Equal(t, lid.STOPPED, s.GetCachedStatus())
+}
+
+func TestReloadKeepsOldProcessWhenNotReady(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "fail-next-start")
+
+	ts, s := NewTestService(t, lid.ServiceConfig{
+		Command: []string{"bash", "-c", fmt.Sprintf("if [ -e %s ]; then exit 1; fi; echo ready; sleep 10", marker)},
+		StdoutReadinessCheck: func(line string) bool {
+			return strings.Contains(line, "ready")
+		},
+	})
+
+	go ts.Start()
+	waitForStatus(t, s, lid.RUNNING, 1*time.Second)
+	oldPid := s.GetPid()
+
+	require.NoError(t, os.WriteFile(marker, nil, 0644))
+	assert.Error(t, s.Reload())
+
+	assert.Equal(t, oldPid, s.GetPid(), "Old process should keep running")
+	assert.Equal(t, lid.RUNNING, s.GetCachedStatus())
+	assert.True(t, s.IsRunning())
+
+	s.Stop()
+	ts.WaitOrTimeout(1 * time.Second)
+}
+
+func TestReloadNotRunning(t *testing.T) {
+	_, s := NewTestService(t, lid.ServiceConfig{
+		Command: []string{"bash", "-c", "sleep 1"},
+	})
+
+	assert.ErrorIs(t, s.Reload(), lid.ErrProcessNotFound)
+}
+
+func TestReloadReportsEarlyFailures(t *testing.T) {
+	stateDir := t.TempDir()
+	defer killStartedProcesses(t, stateDir)
+
+	envFile := filepath.Join(stateDir, "worker.env")
+	require.NoError(t, os.WriteFile(envFile, []byte("PORT=8080\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(stateDir, "lid.yaml"), []byte(`
+services:
+  worker:
+    command: [bash, -c, 'echo $$ >> pids; echo "worker ready"; exec sleep 30']
+    env_files: [worker.env]
+    readiness_pattern: 'worker ready'
+    readiness_check_timeout: 10s
+`), 0644))
+
+	output, code := runTestLidOutput(t, stateDir, testLidOptions{}, "start", "worker")
+	require.Equal(t, lid.EXIT_OK, code, output)
+	pid := servicePid(t, stateDir, "worker")
+
+	// the new process cannot even be prepared without its env file
+	require.NoError(t, os.Remove(envFile))
+	start := time.Now()
+	output, code = runTestLidOutput(t, stateDir, testLidOptions{}, "reload", "worker")
+	assert.Equal(t, lid.EXIT_FAILURE, code)
+	assert.Less(t, time.Since(start), 5*time.Second, "The reload should not wait for its timeout")
+	assert.Contains(t, output, "file does not exist")
+	assert.NotContains(t, output, "timed out")
+
+	state, err := lid.ReadServiceProcess(filepath.Join(stateDir, "service-worker.lid"))
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), state.ReloadFailures)
+	assert.Contains(t, state.ReloadError, "file does not exist")
+	assert.Equal(t, pid, state.Pid, "The old process should keep running")
+
+	_, code = runTestLidOutput(t, stateDir, testLidOptions{}, "stop", "worker")
+	assert.Equal(t, lid.EXIT_OK, code)
+}
+
+func TestReloadRecordsConfigHash(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, ".env")
+	require.NoError(t, os.WriteFile(envFile, []byte("PORT=8080\n"), 0644))
+
+	ts, s := NewTestService(t, lid.ServiceConfig{
+		Command:  []string{"bash", "-c", "echo ready; exec sleep 10"},
+		Cwd:      dir,
+		EnvFiles: []string{".env"},
+		StdoutReadinessCheck: func(line string) bool {
+			return line == "ready"
+		},
+	})
+
+	go ts.Start()
+	waitForStatus(t, s, lid.RUNNING, 1*time.Second)
+
+	require.NoError(t, os.WriteFile(envFile, []byte("PORT=9090\n"), 0644))
+	require.NoError(t, s.Reload())
+
+	hash, err := s.ConfigHash()
+	require.NoError(t, err)
+	state, err := lid.ReadServiceProcess(s.GetServiceProcessFilename())
+	require.NoError(t, err)
+	assert.Equal(t, hash, state.ConfigHash, "The state should describe the new process")
+
+	s.Stop()
+	ts.WaitOrTimeout(1 * time.Second)
+}
@@ -0,0 +1,177 @@
+package lid_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/robo-monk/lid/lid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestartOnFailure(t *testing.T) {
+	runs := 0
+
+	ts, s := NewTestService(t, lid.ServiceConfig{
+		Command:       []string{"bash", "-c", "exit 3"},
+		RestartPolicy: lid.RestartOnFailure,
+		MaxRestarts:   2,
+		RestartDelay:  10 * time.Millisecond,
+		OnAfterStart: func(self *lid.Service) {
+			runs++
+		},
+	})
+
+	go ts.Start()
+	ts.WaitOrTimeout(2 * time.Second)
+
+	assert.Equal(t, 3, runs, "Service should run once and be restarted twice")
+	assert.Equal(t, lid.FATAL, s.GetCachedStatus(), "Service should give up after MaxRestarts")
+
+	state, err := lid.ReadServiceProcess(s.GetServiceProcessFilename())
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), state.Restarts)
+	assert.Equal(t, int32(3), state.ExitCode)
+}
+
+func TestRestartOnFailureIgnoresCleanExit(t *testing.T) {
+	runs := 0
+
+	ts, s := NewTestService(t, lid.ServiceConfig{
+		Command:       []string{"bash", "-c", "exit 0"},
+		RestartPolicy: lid.RestartOnFailure,
+		RestartDelay:  10 * time.Millisecond,
+		OnAfterStart: func(self *lid.Service) {
+			runs++
+		},
+	})
+
+	go ts.Start()
+	ts.WaitOrTimeout(1 * time.Second)
+
+	assert.Equal(t, 1, runs, "Service should not be restarted after a clean exit")
+	assert.Equal(t, lid.EXITED, s.GetCachedStatus())
+}
+
+func TestRestartAlwaysStopsWhenStopped(t *testing.T) {
+	ts, s := NewTestService(t, lid.ServiceConfig{
+		Command:       []string{"bash", "-c", "exit 0"},
+		RestartPolicy: lid.RestartUnlessStopped,
+		RestartDelay:  200 * time.Millisecond,
+	})
+
+	go ts.Start()
+
+	// Wait for the first exit, the service is now waiting to be restarted
+	time.Sleep(100 * time.Millisecond)
+	s.Stop()
+
+	ts.WaitOrTimeout(1 * time.Second)
+	assert.Equal(t, lid.STOPPED, s.GetCachedStatus())
+}
+
+func TestCrashLoopBecomesFatal(t *testing.T) {
+	runs := 0
+	fatalCalled := false
+
+	ts, s := NewTestService(t, lid.ServiceConfig{
+		Command:            []string{"bash", "-c", "exit 1"},
+		RestartPolicy:      lid.RestartUnlessStopped,
+		RestartDelay:       10 * time.Millisecond,
+		CrashLoopThreshold: 3,
+		CrashLoopWindow:    time.Minute,
+		OnAfterStart: func(self *lid.Service) {
+			runs++
+		},
+		OnFatal: func(self *lid.Service) {
+			fatalCalled = true
+		},
+	})
+
+	go ts.Start()
+	ts.WaitOrTimeout(2 * time.Second)
+
+	assert.Equal(t, 3, runs, "Service should stop restarting after 3 exits")
+	assert.True(t, fatalCalled, "OnFatal should have been called")
+	assert.Equal(t, lid.FATAL, s.GetCachedStatus())
+
+	assert.NoError(t, s.ClearFatal())
+	assert.Equal(t, lid.STOPPED, s.GetCachedStatus())
+}
+
+func TestBackoffStatus(t *testing.T) {
+	ts, s := NewTestService(t, lid.ServiceConfig{
+		Command:       []string{"bash", "-c", "exit 1"},
+		RestartPolicy: lid.RestartOnFailure,
+		RestartDelay:  500 * time.Millisecond,
+	})
+
+	go ts.Start()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, lid.BACKOFF, s.GetCachedStatus(), "Service should be waiting to restart")
+
+	s.Stop()
+	ts.WaitOrTimeout(1 * time.Second)
+	assert.Equal(t, lid.STOPPED, s.GetCachedStatus())
+}
+
+func TestStartLeavesBackoffToTheSupervisor(t *testing.T) {
+	stateDir := t.TempDir()
+	defer killStartedProcesses(t, stateDir)
+
+	require.NoError(t, os.WriteFile(filepath.Join(stateDir, "lid.yaml"), []byte(`
+services:
+  flaky:
+    command: [bash, -c, 'echo $$ >> pids; echo ready; sleep 0.2; exit 1']
+    readiness_pattern: ready
+    restart_policy: on-failure
+    restart_delay: 30s
+`), 0644))
+
+	output, code := runTestLidOutput(t, stateDir, testLidOptions{}, "start", "flaky")
+	require.Equal(t, lid.EXIT_OK, code, output)
+	require.Eventually(t, func() bool {
+		state, err := lid.ReadServiceProcess(filepath.Join(stateDir, "service-flaky.lid"))
+		return err == nil && state.Status == lid.BACKOFF
+	}, 2*time.Second, 20*time.Millisecond)
+
+	results, code := runTestLidJSON(t, stateDir, "start", "flaky")
+	assert.Equal(t, lid.EXIT_OK, code)
+	require.Len(t, results, 1)
+	assert.Equal(t, lid.OUTCOME_BACKING_OFF, results[0].Outcome)
+
+	// ends the backoff, with nothing running to stop
+	runTestLidOutput(t, stateDir, testLidOptions{}, "stop", "flaky")
+	state, err := lid.ReadServiceProcess(filepath.Join(stateDir, "service-flaky.lid"))
+	require.NoError(t, err)
+	assert.Equal(t, lid.STOPPED, state.Status)
+}
+
+func TestDaemonStartLeavesBackoffToTheSupervisor(t *testing.T) {
+	manager := NewTestLid(t)
+	manager.Register("flaky", lid.ServiceConfig{
+		Command:       []string{"bash", "-c", "echo ready; sleep 0.2; exit 1"},
+		RestartPolicy: lid.RestartOnFailure,
+		RestartDelay:  30 * time.Second,
+		StdoutReadinessCheck: func(line string) bool {
+			return line == "ready"
+		},
+	})
+
+	daemon, err := lid.NewDaemon(manager)
+	require.NoError(t, err)
+	defer daemon.Shutdown()
+
+	results := manager.StartServices([]string{"flaky"})
+	require.Equal(t, lid.OUTCOME_STARTED, results[0].Outcome)
+	require.Eventually(t, func() bool {
+		return manager.Statuses([]string{"flaky"})[0].Status == lid.BACKOFF
+	}, 2*time.Second, 20*time.Millisecond)
+
+	results = manager.StartServices([]string{"flaky"})
+	assert.Equal(t, lid.OUTCOME_BACKING_OFF, results[0].Outcome, "The daemon should not report a start it did not do")
+	assert.Equal(t, lid.NO_PID, results[0].Pid)
+}
@@ -2,7 +2,9 @@ package lid_test
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
@@ -20,6 +22,10 @@ type TestService struct {
 }
 
 func NewTestService(t *testing.T, s lid.ServiceConfig) (*TestService, *lid.Service) {
+	if s.StateDir == "" {
+		s.StateDir = t.TempDir()
+	}
+
 	ts := &TestService{
 		t:        t,
 		chanDone: make(chan struct{}),
@@ -106,6 +112,30 @@ func TestNewServiceStartStop(t *testing.T) {
 	assert.Equal(t, lid.NO_PID, s.GetPid(), "PID should be 0")
 }
 
+func TestStopWhenExitCommandCannotBePrepared(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, ".env")
+	require.NoError(t, os.WriteFile(envFile, []byte("PORT=8080\n"), 0644))
+
+	ts, s := NewTestService(t, lid.ServiceConfig{
+		Command:     []string{"sleep", "30"},
+		Cwd:         dir,
+		EnvFiles:    []string{".env"},
+		ExitCommand: []string{"true"},
+	})
+
+	go ts.Start()
+	waitForStatus(t, s, lid.RUNNING, time.Second)
+
+	// the exit command cannot get its environment anymore
+	require.NoError(t, os.Remove(envFile))
+	assert.NoError(t, s.Stop(), "ExitSignal should be sent instead")
+
+	ts.WaitOrTimeout(2 * time.Second)
+	assert.Equal(t, lid.STOPPED, s.GetCachedStatus())
+	assert.False(t, s.IsRunning())
+}
+
 func TestNewServiceOnExit(t *testing.T) {
 	recievedErrorCode := -1
 
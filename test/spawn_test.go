@@ -0,0 +1,95 @@
+package lid_test
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/robo-monk/lid/lid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitForSupervisor waits for the supervisor of a service to let go of its
+// lock, so it is done with the state directory.
+func waitForSupervisor(t *testing.T, stateDir, service string) {
+	file, err := os.OpenFile(filepath.Join(stateDir, "service-"+service+".supervisor.lock"), os.O_CREATE|os.O_RDWR, 0644)
+	require.NoError(t, err)
+	defer file.Close()
+
+	require.NoError(t, syscall.Flock(int(file.Fd()), syscall.LOCK_EX))
+	syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
+
+func TestStartReportsReadiness(t *testing.T) {
+	t.Parallel()
+	stateDir := t.TempDir()
+	defer killStartedProcesses(t, stateDir)
+
+	output, code := runTestLidOutput(t, stateDir, testLidOptions{}, "start", "backend")
+	assert.Equal(t, 0, code, output)
+	assert.Contains(t, output, "Started successfully")
+
+	state, err := lid.ReadServiceProcess(filepath.Join(stateDir, "service-backend.lid"))
+	require.NoError(t, err)
+	assert.Equal(t, lid.RUNNING, state.Status)
+	assert.Equal(t, startedPids(t, stateDir), []int32{state.Pid}, "The service should be ready once start returns")
+
+	_, code = runTestLidOutput(t, stateDir, testLidOptions{}, "stop", "backend")
+	assert.Equal(t, 0, code)
+}
+
+func TestStartReportsFailures(t *testing.T) {
+	t.Parallel()
+
+	for service, reason := range map[string]string{
+		"impostor": "readiness check timed out",
+		"crasher":  "exited with code 3 before becoming ready",
+		"rejected": "maintenance window",
+	} {
+		t.Run(service, func(t *testing.T) {
+			t.Parallel()
+			stateDir := t.TempDir()
+
+			output, code := runTestLidOutput(t, stateDir, testLidOptions{}, "start", service)
+			waitForSupervisor(t, stateDir, service)
+
+			assert.Equal(t, 1, code, output)
+			assert.Contains(t, output, reason)
+			assert.Contains(t, output, "service failed to start: "+service)
+			assert.NotContains(t, output, "Started successfully")
+		})
+	}
+}
+
+func TestServicesDoNotInheritTheSpawnPipe(t *testing.T) {
+	stateDir := t.TempDir()
+	defer killStartedProcesses(t, stateDir)
+
+	// every service records what its fd 3 is, if anything
+	require.NoError(t, os.WriteFile(filepath.Join(stateDir, "lid.yaml"), []byte(`
+services:
+  plain:
+    command: [bash, -c, 'echo $$ >> pids; readlink /proc/$$/fd/3 > plain.fd3; echo ready; exec sleep 30']
+    readiness_pattern: ready
+  listening:
+    command: [bash, -c, 'echo $$ >> pids; readlink /proc/$$/fd/3 > listening.fd3; echo ready; exec sleep 30']
+    readiness_pattern: ready
+    listeners: ['tcp://127.0.0.1:0']
+`), 0644))
+
+	output, code := runTestLidOutput(t, stateDir, testLidOptions{}, "start", "plain", "listening")
+	require.Equal(t, lid.EXIT_OK, code, output)
+
+	fd3, err := os.ReadFile(filepath.Join(stateDir, "plain.fd3"))
+	require.NoError(t, err)
+	assert.Empty(t, string(fd3), "The service should not have a fd 3")
+
+	fd3, err = os.ReadFile(filepath.Join(stateDir, "listening.fd3"))
+	require.NoError(t, err)
+	assert.Contains(t, string(fd3), "socket:[", "The fd 3 of the service should be its listener")
+
+	_, code = runTestLidOutput(t, stateDir, testLidOptions{}, "stop", "plain", "listening")
+	assert.Equal(t, lid.EXIT_OK, code)
+}
@@ -0,0 +1,95 @@
+package lid_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robo-monk/lid/lid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceInfoJSON(t *testing.T) {
+	started := time.Date(2024, 10, 17, 10, 30, 0, 0, time.UTC)
+	info := lid.ServiceInfo{
+		Name:      "api",
+		Status:    lid.RUNNING,
+		Pid:       4242,
+		StartTime: started,
+		Uptime:    90 * time.Second,
+		Restarts:  2,
+		ExitCode:  1,
+		HasExited: true,
+		Command:   []string{"node", "server.js"},
+		Cwd:       "/srv/api",
+	}
+
+	data, err := json.Marshal(info)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"name": "api",
+		"status": "running",
+		"pid": 4242,
+		"start_time": "2024-10-17T10:30:00Z",
+		"uptime": 90,
+		"cpu": 0,
+		"memory_rss": 0,
+		"restarts": 2,
+		"exit_code": 1,
+		"command": ["node", "server.js"],
+		"cwd": "/srv/api"
+	}`, string(data), "Health should be left out without a health check")
+
+	var parsed lid.ServiceInfo
+	require.NoError(t, json.Unmarshal(data, &parsed))
+	assert.Equal(t, info, parsed)
+}
+
+func TestStatusCommand(t *testing.T) {
+	t.Parallel()
+	stateDir := t.TempDir()
+	defer killStartedProcesses(t, stateDir)
+
+	_, code := runTestLidOutput(t, stateDir, testLidOptions{StdoutOnly: true}, "status", "backend")
+	assert.Equal(t, lid.EXIT_NOT_RUNNING, code, "A stopped service should not count as up")
+
+	_, code = runTestLidOutput(t, stateDir, testLidOptions{}, "start", "backend")
+	require.Equal(t, lid.EXIT_OK, code)
+	pid := startedPids(t, stateDir)[0]
+
+	output, code := runTestLidOutput(t, stateDir, testLidOptions{StdoutOnly: true}, "status", "backend")
+	assert.Equal(t, lid.EXIT_OK, code)
+	assert.Regexp(t, `Status:\s+.*Running`, output)
+	assert.Regexp(t, `Command:\s+bash -c 'echo \$\$ >> "\$0"; echo "backend started"; exec sleep 30' `, output)
+	assert.Regexp(t, `Env files:\s+-`, output)
+
+	output, code = runTestLidOutput(t, stateDir, testLidOptions{StdoutOnly: true}, "status", "backend", "--json")
+	assert.Equal(t, lid.EXIT_OK, code)
+	var info lid.ServiceInfo
+	require.NoError(t, json.Unmarshal([]byte(output), &info))
+	assert.Equal(t, "backend", info.Name)
+	assert.Equal(t, lid.RUNNING, info.Status)
+	assert.Equal(t, pid, info.Pid)
+	assert.Equal(t, "bash", info.Command[0])
+	assert.WithinDuration(t, time.Now(), info.StartTime, 10*time.Second)
+
+	output, code = runTestLidOutput(t, stateDir, testLidOptions{StdoutOnly: true}, "list", "--format", "{{.Name}} {{.Status}} {{.Pid}}")
+	assert.Equal(t, lid.EXIT_OK, code)
+	assert.Contains(t, strings.Split(output, "\n"), fmt.Sprintf("backend Running %d", pid))
+	assert.Contains(t, strings.Split(output, "\n"), "impostor Stopped 0")
+
+	_, code = runTestLidOutput(t, stateDir, testLidOptions{}, "status")
+	assert.Equal(t, lid.EXIT_USAGE, code, "status should need a service")
+
+	_, code = runTestLidOutput(t, stateDir, testLidOptions{}, "list", "--format", "{{.Name")
+	assert.Equal(t, lid.EXIT_USAGE, code, "An invalid template should be reported")
+
+	_, code = runTestLidOutput(t, stateDir, testLidOptions{}, "stop", "backend")
+	require.Equal(t, lid.EXIT_OK, code)
+
+	_, code = runTestLidOutput(t, stateDir, testLidOptions{StdoutOnly: true}, "status", "backend")
+	assert.Equal(t, lid.EXIT_NOT_RUNNING, code)
+}
@@ -0,0 +1,150 @@
+package lid_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robo-monk/lid/lid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// configProblems returns the problems in err as "Field: message" lines.
+func configProblems(t *testing.T, err error) []string {
+	var errs lid.ConfigErrors
+	require.ErrorAs(t, err, &errs)
+
+	problems := make([]string, len(errs))
+	for i, err := range errs {
+		problems[i] = err.Field + ": " + err.Err.Error()
+	}
+	return problems
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".env"), []byte("PORT=8080\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "run.sh"), []byte("#!/bin/sh\n"), 0755))
+
+	assert.NoError(t, lid.ServiceConfig{
+		Command:     []string{"./run.sh"},
+		Cwd:         dir,
+		EnvFiles:    []string{".env", ".env.local?"},
+		ExitCommand: []string{"true"},
+	}.Validate())
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.env"), []byte("PORT\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "data.txt"), nil, 0644))
+
+	err := lid.ServiceConfig{
+		Command:     []string{"./data.txt"},
+		Cwd:         dir,
+		EnvFiles:    []string{"missing.env", "broken.env", "optional.env?"},
+		Env:         []string{"PORT"},
+		ExitCommand: []string{},
+		HealthCheck: &lid.HealthCheck{Exec: []string{"lid-test-no-such-program"}},
+	}.Validate()
+	assert.Equal(t, []string{
+		"Command: " + filepath.Join(dir, "data.txt") + " is not executable",
+		"ExitCommand: command is empty",
+		`HealthCheck.Exec: exec: "lid-test-no-such-program": executable file not found in $PATH`,
+		"EnvFiles: env file " + filepath.Join(dir, "missing.env") + `: file does not exist (append "?" to its name in EnvFiles if it is optional)`,
+		"EnvFiles: " + filepath.Join(dir, "broken.env") + `:1: expected KEY=value, got "PORT"`,
+		`Env: "PORT" is not KEY=value`,
+	}, configProblems(t, err))
+
+	err = lid.ServiceConfig{Cwd: filepath.Join(dir, "nowhere")}.Validate()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.ErrorIs(t, err, lid.ErrEmptyCommand)
+}
+
+func TestRegisterErrors(t *testing.T) {
+	manager := NewTestLid(t)
+	require.NoError(t, manager.Register("api", lid.ServiceConfig{Command: []string{"true"}}))
+
+	for name, tc := range map[string]struct {
+		service string
+		config  lid.ServiceConfig
+		err     error
+	}{
+		"taken name":         {"api", lid.ServiceConfig{Command: []string{"true"}}, lid.ErrServiceRegistered},
+		"empty name":         {"", lid.ServiceConfig{Command: []string{"true"}}, lid.ErrInvalidServiceName},
+		"slash":              {"../api", lid.ServiceConfig{Command: []string{"true"}}, lid.ErrInvalidServiceName},
+		"empty command":      {"worker", lid.ServiceConfig{}, lid.ErrEmptyCommand},
+		"empty exit command": {"worker", lid.ServiceConfig{Command: []string{"true"}, ExitCommand: []string{}}, lid.ErrEmptyCommand},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := manager.Register(tc.service, tc.config)
+			assert.ErrorIs(t, err, tc.err)
+
+			var configErr *lid.ConfigError
+			require.ErrorAs(t, err, &configErr)
+			assert.Equal(t, tc.service, configErr.Service)
+		})
+	}
+
+	assert.Len(t, configProblems(t, manager.Validate(nil)), 5, "Validate should report the rejected services")
+}
+
+func TestLidValidateDependencies(t *testing.T) {
+	manager := NewTestLid(t)
+	manager.Register("a", lid.ServiceConfig{Command: []string{"true"}, DependsOn: []string{"b", "db"}})
+	manager.Register("b", lid.ServiceConfig{Command: []string{"true"}, DependsOn: []string{"a", "cache"}})
+
+	err := manager.Validate(nil)
+	assert.Equal(t, "service 'a': DependsOn: unknown service 'db'\n"+
+		"service 'b': DependsOn: unknown service 'cache'\n"+
+		"DependsOn: dependency cycle: a -> b -> a", err.Error())
+
+	err = manager.Validate([]string{"b"})
+	assert.Equal(t, []string{
+		"DependsOn: unknown service 'cache'",
+		"DependsOn: dependency cycle: a -> b -> a",
+	}, configProblems(t, err))
+}
+
+func TestCheckCommand(t *testing.T) {
+	stateDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(stateDir, "lid.yaml"), []byte(`
+services:
+  broken:
+    command: [lid-test-no-such-program]
+    env_files: [missing.env]
+    depends_on: [nowhere]
+  empty:
+    command: []
+`), 0644))
+
+	output, code := runTestLidOutput(t, stateDir, testLidOptions{StdoutOnly: true}, "check")
+	assert.Equal(t, lid.EXIT_FAILURE, code)
+	assert.Equal(t, []string{
+		"service 'empty': Command: command is empty",
+		`service 'broken': Command: exec: "lid-test-no-such-program": executable file not found in $PATH`,
+		"service 'broken': EnvFiles: env file " + filepath.Join(stateDir, "missing.env") + `: file does not exist (append "?" to its name in EnvFiles if it is optional)`,
+		"service 'broken': DependsOn: unknown service 'nowhere'",
+	}, strings.Split(strings.TrimSpace(output), "\n"))
+
+	output, code = runTestLidOutput(t, stateDir, testLidOptions{StdoutOnly: true}, "check", "--json", "broken")
+	assert.Equal(t, lid.EXIT_FAILURE, code)
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	require.Len(t, lines, 3)
+	var problem map[string]string
+	require.NoError(t, json.Unmarshal([]byte(lines[2]), &problem))
+	assert.Equal(t, map[string]string{"service": "broken", "field": "DependsOn", "error": "unknown service 'nowhere'"}, problem)
+
+	output, code = runTestLidOutput(t, stateDir, testLidOptions{}, "check", "backend")
+	assert.Equal(t, lid.EXIT_OK, code)
+	assert.Equal(t, "1 services OK\n", output)
+
+	output, code = runTestLidOutput(t, stateDir, testLidOptions{}, "start", "backend")
+	assert.Equal(t, lid.EXIT_FAILURE, code, "Other commands should refuse to run with a rejected service")
+	assert.Contains(t, output, "service 'empty': Command: command is empty")
+}
+
+func TestEmptyCommandDoesNotPanic(t *testing.T) {
+	_, s := NewTestService(t, lid.ServiceConfig{})
+	assert.ErrorIs(t, s.Start(), lid.ErrEmptyCommand)
+}